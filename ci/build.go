@@ -3,35 +3,120 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"dagger.io/dagger"
 )
 
+var force bool
+
 func build(ctx context.Context) error {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	fs.BoolVar(&force, "force", false, "Builds even if the function is unchanged since the last successful build")
+	fs.StringVar(&goVersion, "go-version", "", "Go version with which to build (e.g. 1.20.1), defaults to the version required by go.mod")
+	fs.StringVar(&goVersions, "go-versions", "", "Comma separated list of Go versions to build with concurrently, reporting the result of each (e.g. 1.19,1.20)")
+	fs.StringVar(&targets, "targets", "", "Comma separated list of platforms to cross-compile binaries for, exported to "+DistDir+"/ with checksums (e.g. linux/amd64,darwin/arm64)")
+	fs.StringVar(&mainPackage, "main", "", "Main package built into binaries for --targets, defaults to a main package scaffolded to serve the function")
+	fs.StringVar(&distVersion, "version", "dev", "Version injected into binaries for --targets as main.version")
+	fs.BoolVar(&fromGit, "git", false, "Builds the source from the function's git repository and revision (build.git) instead of the local directory")
+	fs.BoolVar(&watch, "watch", false, "Rebuilds every time the source of the function changes")
+	fs.DurationVar(&debounce, "debounce", 300*time.Millisecond, "Time to wait for further changes before rebuilding with --watch")
+	addGoModulesFlags(fs)
+	fs.Parse(os.Args[2:])
+
+	if err := exportGoProxy(); err != nil {
+		return err
+	}
+
+	fn, err := NewFunction(".")
+	if err != nil {
+		return err
+	}
+
+	targetList, err := parsePlatforms(targets)
+	if err != nil {
+		return err
+	}
+	if len(targetList) > 0 && goVersions != "" {
+		return errors.New("building for targets can not be combined with a Go version matrix")
+	}
+
+	if watch {
+		if fromGit {
+			return errWatchGit
+		}
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		c := getDaggerClient(ctx)
+		defer c.Close()
+
+		return watchSource(ctx, fn.Root, func(fn Function) error {
+			return buildFunction(ctx, c, fn, targetList)
+		})
+	}
+
+	extra := append([]string{fmt.Sprintf("git=%v", fromGit), "go-version=" + goVersion, "go-versions=" + goVersions,
+		"targets=" + targets, "main=" + mainPackage, "version=" + distVersion, "commit=" + sourceCommit(fn)},
+		goModulesFingerprint()...)
+	fingerprint, err := fn.Fingerprint(extra...)
+	if err != nil {
+		return err
+	}
+	if !force && isUpToDate(fn, "build", fingerprint) {
+		fmt.Println("Function unchanged since the last successful build, skipping (use --force to build anyway)")
+		return nil
+	}
+
 	c := getDaggerClient(ctx)
 	defer c.Close()
 
-	appDir := c.Host().Directory(".", dagger.HostDirectoryOpts{
-		Exclude: []string{"ci"},
-	})
+	if err = buildFunction(ctx, c, fn, targetList); err != nil {
+		return err
+	}
 
-	ctr := getGoContainer(c)
+	return fn.Stamp("build", fingerprint)
+}
 
-	_, err := ctr.WithMountedDirectory("/app", appDir).
-		WithExec([]string{"go", "build", "./..."}).
-		ExitCode(ctx)
+// buildFunction builds the source of the function, or binaries of it for the
+// given targets if any.
+func buildFunction(ctx context.Context, c *dagger.Client, fn Function, targetList []dagger.Platform) error {
+	appDir, err := functionSource(c, fn)
 	if err != nil {
-		return errors.New(err.Error())
+		return err
+	}
+
+	if len(targetList) > 0 {
+		return buildTargets(ctx, c, fn, appDir, targetList)
 	}
+	return runGo(ctx, c, fn, appDir, []string{"go", "build", "./..."})
+}
 
-	return nil
+// isUpToDate returns whether the given stage of the function was already
+// built successfully with the given fingerprint.  Sources fetched from git
+// are only considered unchanged when pinned to a commit.
+func isUpToDate(fn Function, stage, fingerprint string) bool {
+	if fromGit && !pinnedGitRevision(fn.Build.Git) {
+		return false
+	}
+	return fn.Built(stage, fingerprint)
 }
 
-func getGoContainer(c *dagger.Client) *dagger.Container {
-	pkgCache := c.CacheVolume("gopkg")
-	buildCache := c.CacheVolume("gocache")
-	return c.Container().From("golang:1.20.1").
+// getGoContainer returns a container of the given Go toolchain image,
+// configured to fetch private modules.  The module cache is shared by all
+// toolchains of the function, while each toolchain has its own build cache.
+func getGoContainer(c *dagger.Client, fn Function, image string) *dagger.Container {
+	pkgCache := functionCache(c, fn, "gopkg")
+	buildCache := functionCache(c, fn, "gocache-"+strings.TrimPrefix(image, goImageRepository+":"))
+	ctr := c.Container().From(image).
 		WithMountedCache("/go/", pkgCache).
 		WithMountedCache("/root/.cache/go-build", buildCache).
 		WithWorkdir("/app")
+	return withGoModules(c, ctr, "/root")
 }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+// packCaches are the names of the cache volumes of the remote build
+var packCaches = []string{"packs_layers", "packs_platform", "packs_cache"}
+
+var (
+	// cacheImage is the image in which the CNB lifecycle stores the cache of
+	// buildpack layers, instead of a cache volume of the engine.
+	cacheImage string
+
+	// cacheImport is the path of a tarball of the cache volumes of the
+	// remote build to restore before building.
+	cacheImport string
+
+	// cacheExport is the path to which a tarball of the cache volumes of the
+	// remote build is written after building.
+	cacheExport string
+)
+
+// errCacheRemote indicates the build caches were requested for a local build
+var errCacheRemote = errors.New("importing or exporting build caches requires --remote")
+
+// packCacheName returns the name of the cache volume of the remote build for
+// the platform.  An empty platform is the default platform of the engine.
+func packCacheName(name string, platform dagger.Platform) string {
+	if platform == "" {
+		return name
+	}
+	return name + "_" + platformSlug(platform)
+}
+
+// packCacheImage returns the cache image of the remote build for the
+// platform, if any.
+func packCacheImage(platform dagger.Platform) (string, error) {
+	if cacheImage == "" || platform == "" {
+		return cacheImage, nil
+	}
+	return platformImage(cacheImage, platform)
+}
+
+// withPackCaches mounts the cache volumes of the remote build of all the
+// platforms of the function under /caches, by name.
+func withPackCaches(c *dagger.Client, fn Function, ctr *dagger.Container, platforms []dagger.Platform) *dagger.Container {
+	if len(platforms) == 0 {
+		platforms = []dagger.Platform{""}
+	}
+	for _, p := range platforms {
+		for _, cache := range packCaches {
+			name := packCacheName(cache, p)
+			ctr = ctr.WithMountedCache("/caches/"+name, functionCache(c, fn, name))
+		}
+	}
+	return ctr
+}
+
+// importPackCaches restores the cache volumes of the remote build of all the
+// platforms of the function from the tarball at path, such that a fresh
+// engine starts warm.
+func importPackCaches(ctx context.Context, c *dagger.Client, fn Function, platforms []dagger.Platform, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("build cache not found: %w", err)
+	}
+	tarball := c.Host().Directory(filepath.Dir(path)).File(filepath.Base(path))
+
+	_, err := withPackCaches(c, fn, c.Container().From("alpine"), platforms).
+		WithEnvVariable("CACHE_BUSTER", strconv.FormatInt(time.Now().UnixNano(), 10)).
+		WithMountedFile("/cache.tar.gz", tarball).
+		WithExec([]string{"tar", "-xzf", "/cache.tar.gz", "-C", "/caches"}).
+		ExitCode(ctx)
+	if err != nil {
+		return fmt.Errorf("importing build cache '%v': %w", path, err)
+	}
+	fmt.Println("Build cache imported from", path)
+	return nil
+}
+
+// exportPackCaches writes a tarball of the cache volumes of the remote build
+// of all the platforms of the function to path.  The contents of cache
+// volumes are not part of the cache key of execs, so the cache is busted for
+// the tarball to reflect the volumes as they are now.
+func exportPackCaches(ctx context.Context, c *dagger.Client, fn Function, platforms []dagger.Platform, path string) error {
+	_, err := withPackCaches(c, fn, c.Container().From("alpine"), platforms).
+		WithEnvVariable("CACHE_BUSTER", strconv.FormatInt(time.Now().UnixNano(), 10)).
+		WithExec([]string{"tar", "-czf", "/cache.tar.gz", "-C", "/caches", "."}).
+		File("/cache.tar.gz").
+		Export(ctx, path)
+	if err != nil {
+		return fmt.Errorf("exporting build cache to '%v': %w", path, err)
+	}
+	fmt.Println("Build cache exported to", path)
+	return nil
+}
+
+// exportRemotePackCaches exports the cache volumes of the remote build of the
+// function without building it, such as when it is unchanged.
+func exportRemotePackCaches(ctx context.Context, fn Function, platforms []dagger.Platform, path string) error {
+	session, err := setupRemoteEngine(ctx, fn.Name)
+	if err != nil {
+		return err
+	}
+	defer session.Release()
+
+	c := getDaggerClient(ctx)
+	defer c.Close()
+
+	return exportPackCaches(ctx, c, fn, platforms, path)
+}
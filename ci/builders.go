@@ -43,17 +43,28 @@ var DefaultBuilderImages = map[string]string{
 //   - ErrRuntimeRequired if no runtime was provided on the given function
 //   - ErrNoDefaultImage if the function has no builder image already defined
 //     for the given runtime and there is no default in the provided map.
+//   - an error if the chosen image is not a valid image reference.
 func Image(f Function, builder string, defaults map[string]string) (string, error) {
 	v, ok := f.Build.BuilderImages[builder]
 	if ok {
-		return v, nil // found value
+		return builderImage(v) // found value
 	}
 	if f.Runtime == "" {
 		return "", ErrRuntimeRequired{Builder: builder}
 	}
 	v, ok = defaults[f.Runtime]
 	if ok {
-		return v, nil // Found default
+		return builderImage(v) // Found default
 	}
 	return "", ErrNoDefaultImage{Builder: builder, Runtime: f.Runtime}
 }
+
+// builderImage validates a builder image reference, returning it in its
+// canonical form.
+func builderImage(image string) (string, error) {
+	ref, err := ParseImageReference(image)
+	if err != nil {
+		return "", fmt.Errorf("invalid builder image: %w", err)
+	}
+	return ref.String(), nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+// cachesFile is the file within RunDataDir recording the names of the cache
+// volumes used by the function, such that they can be listed and pruned.
+const cachesFile = "caches"
+
+// cachesMu serializes recording the cache volumes of concurrent builds
+var cachesMu sync.Mutex
+
+// cacheNamespace returns the prefix of the names of the cache volumes of the
+// function.  Caches are keyed by the name, runtime and builder image of the
+// function, such that functions sharing an engine never share (and corrupt)
+// each other's caches.
+func cacheNamespace(fn Function) string {
+	builder, err := Image(fn, fn.Build.Builder, DefaultBuilderImages)
+	if err != nil {
+		builder = fn.Build.Builder
+	}
+	h := sha256.Sum256([]byte(strings.Join([]string{fn.Name, fn.Runtime, builder}, "\x00")))
+
+	name := fn.Name
+	if name == "" {
+		name = "fn"
+	}
+	return name + "-" + hex.EncodeToString(h[:])[:12]
+}
+
+// functionCache returns the cache volume of the function with the given name,
+// recording it for the cache command.  Failing to record the cache does not
+// fail the build.
+func functionCache(c *dagger.Client, fn Function, name string) *dagger.CacheVolume {
+	if err := recordCache(fn, name); err != nil {
+		fmt.Println("Warning: unable to record cache volume:", err)
+	}
+	return c.CacheVolume(cacheNamespace(fn) + "-" + name)
+}
+
+// recordCache adds the cache of the function with the given name to the
+// caches file, if not recorded yet.
+func recordCache(fn Function, name string) error {
+	cachesMu.Lock()
+	defer cachesMu.Unlock()
+
+	names, err := recordedCaches(fn)
+	if err != nil {
+		return err
+	}
+	for _, n := range names {
+		if n == name {
+			return nil
+		}
+	}
+	return writeRecordedCaches(fn, append(names, name))
+}
+
+// recordedCaches returns the names of the caches recorded for the function
+func recordedCaches(fn Function) ([]string, error) {
+	f, err := os.Open(filepath.Join(fn.Root, RunDataDir, cachesFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var names []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if name := strings.TrimSpace(s.Text()); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, s.Err()
+}
+
+// writeRecordedCaches replaces the caches recorded for the function
+func writeRecordedCaches(fn Function, names []string) error {
+	sort.Strings(names)
+	path := filepath.Join(fn.Root, RunDataDir, cachesFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	contents := ""
+	for _, name := range names {
+		contents += name + "\n"
+	}
+	return os.WriteFile(path, []byte(contents), 0o644)
+}
+
+func cache(ctx context.Context) error {
+	if len(os.Args) < 3 {
+		return errors.New("please specify a cache command: list or prune")
+	}
+
+	fs := flag.NewFlagSet("cache "+os.Args[2], flag.ExitOnError)
+	fs.BoolVar(&remote, "remote", false, "Uses the caches of the remote engine")
+	addKubeFlags(fs)
+	all := fs.Bool("all", false, "Prunes all the caches of the function")
+	fs.Parse(os.Args[3:])
+
+	fn, err := NewFunction(".")
+	if err != nil {
+		return err
+	}
+	names, err := recordedCaches(fn)
+	if err != nil {
+		return err
+	}
+
+	// the caches of the function are on the engine of the pool selected for
+	// the function
+	if remote {
+		session, err := setupRemoteEngine(ctx, fn.Name)
+		if err != nil {
+			return err
+		}
+		defer session.Release()
+	}
+
+	switch os.Args[2] {
+	case "list":
+		if len(names) == 0 {
+			fmt.Println("No caches recorded for the function")
+			return nil
+		}
+		return listCaches(ctx, fn, names)
+
+	case "prune":
+		prune := fs.Args()
+		if *all {
+			prune = names
+		}
+		if len(prune) == 0 {
+			return errors.New("please specify the caches to prune, or --all")
+		}
+		return pruneCaches(ctx, fn, names, prune)
+
+	default:
+		return fmt.Errorf("invalid cache command '%v'", os.Args[2])
+	}
+}
+
+// cacheContainer returns a container with the given caches of the function
+// mounted under /caches, by name.  The contents of cache volumes are not part
+// of the cache key of execs, so the cache is busted for execs of the
+// container to see, and act on, the volumes as they are now.
+func cacheContainer(ctx context.Context, fn Function, names []string) (*dagger.Client, *dagger.Container) {
+	c := getDaggerClient(ctx)
+
+	ctr := c.Container().From("alpine").
+		WithEnvVariable("CACHE_BUSTER", strconv.FormatInt(time.Now().UnixNano(), 10))
+	for _, name := range names {
+		ctr = ctr.WithMountedCache("/caches/"+name, c.CacheVolume(cacheNamespace(fn)+"-"+name))
+	}
+	return c, ctr
+}
+
+// listCaches prints the caches of the function with their volume names and
+// size on the engine.
+func listCaches(ctx context.Context, fn Function, names []string) error {
+	c, ctr := cacheContainer(ctx, fn, names)
+	defer c.Close()
+
+	out, err := ctr.WithWorkdir("/caches").
+		WithExec(append([]string{"du", "-sh"}, names...)).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	sizes := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			sizes[fields[1]] = fields[0]
+		}
+	}
+	fmt.Printf("%-24v %-40v %v\n", "CACHE", "VOLUME", "SIZE")
+	for _, name := range names {
+		fmt.Printf("%-24v %-40v %v\n", name, cacheNamespace(fn)+"-"+name, sizes[name])
+	}
+	return nil
+}
+
+// pruneCaches empties the given caches of the function on the engine and
+// stops recording them.
+func pruneCaches(ctx context.Context, fn Function, recorded, prune []string) error {
+	for _, name := range prune {
+		found := false
+		for _, r := range recorded {
+			found = found || r == name
+		}
+		if !found {
+			return fmt.Errorf("cache '%v' is not recorded for the function", name)
+		}
+	}
+
+	c, ctr := cacheContainer(ctx, fn, prune)
+	defer c.Close()
+
+	cmd := []string{"find"}
+	for _, name := range prune {
+		cmd = append(cmd, "/caches/"+name)
+	}
+	cmd = append(cmd, "-mindepth", "1", "-delete")
+	if _, err := ctr.WithExec(cmd).ExitCode(ctx); err != nil {
+		return err
+	}
+
+	var kept []string
+	for _, r := range recorded {
+		pruned := false
+		for _, name := range prune {
+			pruned = pruned || r == name
+		}
+		if !pruned {
+			kept = append(kept, r)
+		}
+	}
+	if err := writeRecordedCaches(fn, kept); err != nil {
+		return err
+	}
+	fmt.Println("Pruned caches:", strings.Join(prune, ", "))
+	return nil
+}
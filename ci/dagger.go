@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"os"
+	"path/filepath"
 
 	"dagger.io/dagger"
 )
@@ -15,3 +16,13 @@ func getDaggerClient(ctx context.Context) *dagger.Client {
 
 	return c
 }
+
+// hostFile returns a file on the host.
+func hostFile(c *dagger.Client, path string) *dagger.File {
+	return c.Host().Directory(filepath.Dir(path)).File(filepath.Base(path))
+}
+
+// hostFileSecret returns a secret of the contents of a file on the host.
+func hostFileSecret(c *dagger.Client, path string) *dagger.Secret {
+	return hostFile(c, path).Secret()
+}
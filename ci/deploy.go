@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// fieldManager is the field manager of the resources applied by the pipeline
+const fieldManager = "dagger-knative"
+
+// knativeServices is the resource of Knative Services
+var knativeServices = schema.GroupVersionResource{
+	Group:    "serving.knative.dev",
+	Version:  "v1",
+	Resource: "services",
+}
+
+// deployNamespace overrides the namespace of the function to deploy into
+var deployNamespace string
+
+func deploy(ctx context.Context) error {
+	fs := flag.NewFlagSet("deploy", flag.ExitOnError)
+	fs.StringVar(&verificationKey, "key", "cosign.pub", "Path of the cosign public key verifying the image before it is deployed")
+	fs.StringVar(&deployNamespace, "namespace", "", "Namespace to deploy into, defaults to the namespace of the function (deploy.namespace)")
+	addCosignFlags(fs)
+	addKubeContextFlag(fs)
+	fs.Parse(os.Args[2:])
+
+	fn, err := NewFunction(".")
+	if err != nil {
+		return err
+	}
+	if err = fn.Validate(); err != nil {
+		return err
+	}
+	if fn.Name == "" {
+		return ErrNameRequired
+	}
+	fn.ImageDigest = fn.PublishedDigest()
+
+	// only signed images are deployed
+	c := getDaggerClient(ctx)
+	err = verifyImage(ctx, c, fn)
+	c.Close()
+	if err != nil {
+		return err
+	}
+
+	namespace := deployNamespace
+	if namespace == "" {
+		namespace = fn.Deploy.Namespace
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	service, err := knativeService(fn, namespace)
+	if err != nil {
+		return err
+	}
+	bb, err := json.Marshal(service)
+	if err != nil {
+		return err
+	}
+
+	clientConfig, err := getKubeClientConfig()
+	if err != nil {
+		return err
+	}
+	client, err := dynamic.NewForConfig(clientConfig)
+	if err != nil {
+		return err
+	}
+
+	forceApply := true
+	_, err = client.Resource(knativeServices).Namespace(namespace).Patch(ctx, fn.Name, types.ApplyPatchType, bb, metav1.PatchOptions{
+		FieldManager: fieldManager,
+		Force:        &forceApply,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Function '%v' deployed to namespace '%v' with image %v\n", fn.Name, namespace, fn.ImageWithDigest())
+	return nil
+}
+
+// knativeService returns the Knative Service of the function, running its
+// published image by digest.
+func knativeService(fn Function, namespace string) (map[string]interface{}, error) {
+	labels, err := fn.LabelsMap()
+	if err != nil {
+		return nil, err
+	}
+	env, envFrom, err := serviceEnvs(fn.Run.Envs)
+	if err != nil {
+		return nil, err
+	}
+
+	container := map[string]interface{}{
+		"image": fn.ImageWithDigest(),
+		"env":   env,
+	}
+	if len(envFrom) > 0 {
+		container["envFrom"] = envFrom
+	}
+
+	return map[string]interface{}{
+		"apiVersion": knativeServices.GroupVersion().String(),
+		"kind":       "Service",
+		"metadata": map[string]interface{}{
+			"name":        fn.Name,
+			"namespace":   namespace,
+			"labels":      labels,
+			"annotations": fn.Deploy.Annotations,
+		},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"labels": labels,
+				},
+				"spec": map[string]interface{}{
+					"containers": []interface{}{container},
+				},
+			},
+		},
+	}, nil
+}
+
+// serviceEnvs returns the container env and envFrom of the function's envs.
+// Keys of Secrets and ConfigMaps are referenced, local env variables are
+// interpolated.
+func serviceEnvs(envs []Env) (env []interface{}, envFrom []interface{}, err error) {
+	env = []interface{}{}
+	for _, e := range envs {
+		if e.Value == nil {
+			continue
+		}
+		v := *e.Value
+
+		if e.Name == nil {
+			if match := regWholeSecret.FindStringSubmatch(v); len(match) == 2 {
+				envFrom = append(envFrom, map[string]interface{}{
+					"secretRef": map[string]interface{}{"name": match[1]},
+				})
+			} else if match := regWholeConfigMap.FindStringSubmatch(v); len(match) == 2 {
+				envFrom = append(envFrom, map[string]interface{}{
+					"configMapRef": map[string]interface{}{"name": match[1]},
+				})
+			}
+			continue
+		}
+
+		if match := regKeyFromSecret.FindStringSubmatch(v); len(match) == 3 {
+			env = append(env, map[string]interface{}{
+				"name": *e.Name,
+				"valueFrom": map[string]interface{}{
+					"secretKeyRef": map[string]interface{}{"name": match[1], "key": match[2]},
+				},
+			})
+			continue
+		}
+		if match := regKeyFromConfigMap.FindStringSubmatch(v); len(match) == 3 {
+			env = append(env, map[string]interface{}{
+				"name": *e.Name,
+				"valueFrom": map[string]interface{}{
+					"configMapKeyRef": map[string]interface{}{"name": match[1], "key": match[2]},
+				},
+			})
+			continue
+		}
+
+		values, err := Interpolate([]Env{e})
+		if err != nil {
+			return nil, nil, err
+		}
+		env = append(env, map[string]interface{}{"name": *e.Name, "value": values[*e.Name]})
+	}
+	return
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"dagger.io/dagger"
+)
+
+// DistDir is the directory of the function on the host to which binaries
+// built for targets are exported.
+const DistDir = "dist"
+
+var (
+	// targets is a comma separated list of platforms for which to build
+	// binaries of the function.
+	targets string
+
+	// mainPackage is the main package built into binaries for targets, or
+	// empty to build a main package scaffolded for the function.
+	mainPackage string
+
+	// distVersion is the version injected into binaries for targets.
+	distVersion string
+)
+
+// buildTargets cross-compiles the main package of the source concurrently for
+// each of the given platforms, exporting the binaries and their checksums to
+// DistDir.  The version and commit are injected into the main package as
+// main.version and main.commit.
+func buildTargets(ctx context.Context, c *dagger.Client, fn Function, src *dagger.Directory, platforms []dagger.Platform) error {
+	if fn.Name == "" {
+		return ErrNameRequired
+	}
+	goImage, err := goToolchainImage(ctx, src, goVersion)
+	if err != nil {
+		return err
+	}
+	ctr := getGoContainer(c, fn, goImage)
+	if err = checkGoContainer(ctx, ctr, goImage); err != nil {
+		return err
+	}
+	src, pkg, err := mainSource(ctx, ctr, src)
+	if err != nil {
+		return err
+	}
+	ctr = ctr.WithMountedDirectory("/app", src).
+		WithEnvVariable("CGO_ENABLED", "0")
+
+	ldflags := fmt.Sprintf("-s -w -X main.version=%v -X main.commit=%v", distVersion, sourceCommit(fn))
+
+	names := make([]string, len(platforms))
+	binaries := make([]*dagger.File, len(platforms))
+	errs := make([]error, len(platforms))
+
+	var wg sync.WaitGroup
+	for i, p := range platforms {
+		wg.Add(1)
+		go func(i int, p dagger.Platform) {
+			defer wg.Done()
+			goos, goarch, goarm := goPlatform(p)
+			names[i] = binaryName(fn.Name, goos, goarch, goarm)
+			out := "/out/" + names[i]
+
+			built := ctr.
+				WithEnvVariable("GOOS", goos).
+				WithEnvVariable("GOARCH", goarch).
+				WithEnvVariable("GOARM", goarm).
+				WithExec([]string{"go", "build", "-trimpath", "-ldflags", ldflags, "-o", out, pkg})
+			if _, err := built.ExitCode(ctx); err != nil {
+				errs[i] = fmt.Errorf("target %v: %w", p, err)
+				return
+			}
+			binaries[i] = built.File(out)
+		}(i, p)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	dist := c.Directory()
+	for i := range platforms {
+		dist = dist.WithFile(names[i], binaries[i])
+	}
+	dist = c.Container().From("alpine").
+		WithMountedDirectory("/dist", dist).
+		WithWorkdir("/dist").
+		WithExec([]string{"sh", "-c", "sha256sum " + strings.Join(names, " ") + " > checksums.txt"}).
+		Directory("/dist")
+
+	if _, err = dist.Export(ctx, filepath.Join(fn.Root, DistDir)); err != nil {
+		return err
+	}
+	for _, name := range names {
+		fmt.Println("Built", filepath.Join(DistDir, name))
+	}
+	return nil
+}
+
+// goPlatform returns the GOOS, GOARCH and GOARM of a platform.  The variant
+// of arm platforms (ex. 'linux/arm/v7') is the GOARM.
+func goPlatform(p dagger.Platform) (goos, goarch, goarm string) {
+	parts := strings.Split(string(p), "/")
+	goos, goarch = parts[0], parts[1]
+	if len(parts) > 2 && goarch == "arm" {
+		goarm = strings.TrimPrefix(parts[2], "v")
+	}
+	return
+}
+
+// binaryName returns the name of the binary of the function for a platform,
+// ex. 'my-function_linux_arm64' or 'my-function_windows_amd64.exe'
+func binaryName(name, goos, goarch, goarm string) string {
+	s := name + "_" + goos + "_" + goarch
+	if goarm != "" {
+		s += "v" + goarm
+	}
+	if goos == "windows" {
+		s += ".exe"
+	}
+	return s
+}
+
+// sourceCommit returns the commit of the source being built: the revision
+// when building a pinned revision from git, otherwise the HEAD of the local
+// repository, or 'unknown' if there is none.
+func sourceCommit(fn Function) string {
+	if fromGit {
+		if pinnedGitRevision(fn.Build.Git) {
+			return fn.Build.Git.Revision
+		}
+		return "unknown"
+	}
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = fn.Root
+	out, err := cmd.Output()
+	if err != nil {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(out))
+}
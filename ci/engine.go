@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// engineName is the name of the StatefulSet and headless Service of the
+	// remote engine, and of its container.
+	engineName = "dagger-engine"
+
+	// engineDataVolume is the volume claim template of the state of the
+	// engine, which includes its build caches.
+	engineDataVolume = "dagger-data"
+
+	// engineDataPath is where the engine keeps its state
+	engineDataPath = "/var/lib/dagger"
+)
+
+var (
+	// engineStorageClass is the storage class of the engine's volume, the
+	// default class of the cluster when empty.
+	engineStorageClass string
+
+	// engineStorageSize is the size of the engine's volume
+	engineStorageSize string
+)
+
+// engineLabels are the labels of the remote engine's resources
+func engineLabels() map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":       engineName,
+		"app.kubernetes.io/managed-by": fieldManager,
+	}
+}
+
+// engineService returns the headless Service governing the engine's
+// StatefulSet.  It publishes no port, as the engine is only reached by port
+// forwarding.
+func engineService() *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   engineName,
+			Labels: engineLabels(),
+		},
+		Spec: v1.ServiceSpec{
+			ClusterIP: v1.ClusterIPNone,
+			Selector:  engineLabels(),
+		},
+	}
+}
+
+// engineStatefulSet returns the StatefulSet of a single engine of the given
+// image and config, keeping its state on a persistent volume such that caches
+// survive the pod being rescheduled.
+func engineStatefulSet(image string, cfg EngineConfig) (*appsv1.StatefulSet, error) {
+	size, err := resource.ParseQuantity(engineStorageSize)
+	if err != nil {
+		return nil, err
+	}
+	var storageClass *string
+	if engineStorageClass != "" {
+		storageClass = &engineStorageClass
+	}
+
+	replicas := int32(1)
+	privileged := true
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        engineName,
+			Labels:      engineLabels(),
+			Annotations: engineTTLAnnotations(),
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:    &replicas,
+			ServiceName: engineName,
+			Selector:    &metav1.LabelSelector{MatchLabels: engineLabels()},
+			// engines of the pool are independent, and a new engine need not
+			// wait for the others to be ready
+			PodManagementPolicy: appsv1.ParallelPodManagement,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: engineLabels(),
+				},
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{
+						{
+							Name:            engineName,
+							Image:           image,
+							Args:            engineArgs(),
+							SecurityContext: &v1.SecurityContext{Privileged: &privileged},
+							VolumeMounts: []v1.VolumeMount{
+								{Name: engineDataVolume, MountPath: engineDataPath},
+							},
+						},
+					},
+				},
+			},
+			VolumeClaimTemplates: []v1.PersistentVolumeClaim{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:   engineDataVolume,
+						Labels: engineLabels(),
+					},
+					Spec: v1.PersistentVolumeClaimSpec{
+						AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+						StorageClassName: storageClass,
+						Resources: v1.ResourceRequirements{
+							Requests: v1.ResourceList{v1.ResourceStorage: size},
+						},
+					},
+				},
+			},
+		},
+	}
+	cfg.apply(&sts.Spec.Template.Spec)
+	return sts, nil
+}
+
+// reconcileEngine checks the existing engine runs the expected image and
+// config.  An engine of another version, or which can not be reached by port
+// forwarding, is replaced with --engine-replace, keeping its volume, and
+// otherwise refused.  A changed config is applied with --engine-replace, and
+// otherwise reported.  Config is only compared when given explicitly, the
+// engine keeping its own otherwise, such that pipelines without config flags
+// neither warn nor overwrite the config of another.
+func reconcileEngine(ctx context.Context, clientset kubernetes.Interface, image string, cfg EngineConfig) error {
+	if err := removeLegacyEngine(ctx, clientset); err != nil {
+		return err
+	}
+	sts, err := clientset.AppsV1().StatefulSets(kubeNamespace).Get(ctx, engineName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	current := &sts.Spec.Template.Spec
+	if !engineConfigGiven() {
+		cfg = engineConfigOf(current)
+	}
+	desired, err := engineStatefulSet(image, cfg)
+	if err != nil {
+		return err
+	}
+
+	var currentImage string
+	var currentArgs []string
+	for _, c := range current.Containers {
+		if c.Name == engineName {
+			currentImage, currentArgs = c.Image, c.Args
+		}
+	}
+	imageChanged := currentImage != image
+	argsChanged := !equality.Semantic.DeepEqual(currentArgs, engineArgs())
+	configChanged := !equality.Semantic.DeepEqual(engineConfigOf(current), cfg)
+
+	if imageChanged && !replaceEngine {
+		return fmt.Errorf("Dagger engine '%v' in namespace '%v' runs %v, while %v is required by Dagger SDK %v; replace it with --engine-replace or select its version with --engine-version",
+			engineName, kubeNamespace, currentImage, image, sdkVersion())
+	}
+	if argsChanged && !replaceEngine {
+		return fmt.Errorf("Dagger engine '%v' in namespace '%v' does not listen on port %d of its loopback interface for port forwarding; replace it with --engine-replace",
+			engineName, kubeNamespace, enginePort)
+	}
+	if configChanged && !replaceEngine {
+		fmt.Printf("Warning: Dagger engine '%v' in namespace '%v' differs from the engine config, apply it with --engine-replace\n", engineName, kubeNamespace)
+	}
+	if !replaceEngine || (!imageChanged && !configChanged && !argsChanged) {
+		return nil
+	}
+
+	if imageChanged {
+		fmt.Printf("Replacing Dagger engine %v with %v\n", currentImage, image)
+	} else {
+		fmt.Println("Updating the Dagger engine")
+	}
+	sts.Spec.Template = desired.Spec.Template
+	if _, err = clientset.AppsV1().StatefulSets(kubeNamespace).Update(ctx, sts, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	if argsChanged {
+		return unpublishEngineService(ctx, clientset)
+	}
+	return nil
+}
+
+// unpublishEngineService removes the ports of the engine's Service, which
+// earlier versions published to the cluster.
+func unpublishEngineService(ctx context.Context, clientset kubernetes.Interface) error {
+	svc, err := clientset.CoreV1().Services(kubeNamespace).Get(ctx, engineName, metav1.GetOptions{})
+	if kerr.IsNotFound(err) || (err == nil && len(svc.Spec.Ports) == 0) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	svc.Spec.Ports = nil
+	_, err = clientset.CoreV1().Services(kubeNamespace).Update(ctx, svc, metav1.UpdateOptions{})
+	return err
+}
+
+// removeLegacyEngine deletes the bare engine pod of earlier versions, which
+// is named as the StatefulSet and owned by nothing.  Left running, it would
+// remain privileged without ever being used.  A missing pod is ignored.
+func removeLegacyEngine(ctx context.Context, clientset kubernetes.Interface) error {
+	pod, err := clientset.CoreV1().Pods(kubeNamespace).Get(ctx, engineName, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(pod.OwnerReferences) > 0 {
+		return nil
+	}
+	fmt.Printf("Removing the legacy Dagger engine pod '%v' in namespace '%v'\n", engineName, kubeNamespace)
+	err = clientset.CoreV1().Pods(kubeNamespace).Delete(ctx, engineName, metav1.DeleteOptions{})
+	if err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+func engine(ctx context.Context) error {
+	if len(os.Args) < 3 {
+		return errors.New("please specify an engine command: install, status, up, down or logs")
+	}
+
+	fs := flag.NewFlagSet("engine "+os.Args[2], flag.ExitOnError)
+	addKubeFlags(fs)
+	volumes := fs.Bool("volumes", false, "Also deletes the cache volumes of the engines (down)")
+	follow := fs.Bool("follow", false, "Streams the logs of the engine (logs)")
+	tail := fs.Int64("tail", -1, "Number of recent lines of the logs to show, all when negative (logs)")
+	fs.StringVar(&bindUsers, "bind-user", "", "Comma separated users allowed to run remote builds, including service accounts as system:serviceaccount:<namespace>:<name> (install)")
+	fs.StringVar(&bindGroups, "bind-group", "", "Comma separated groups allowed to run remote builds (install)")
+	ordinal := fs.Int("ordinal", 0, "Ordinal of the engine of the pool to show the logs of (logs)")
+	fs.Parse(os.Args[3:])
+
+	clientset, err := getKubeClientset()
+	if err != nil {
+		return err
+	}
+
+	switch os.Args[2] {
+	case "install":
+		return installEngine(ctx, clientset)
+
+	case "status":
+		return engineStatus(ctx, clientset)
+
+	case "up":
+		pod, session, err := acquireEngine(ctx, clientset, "")
+		if err != nil {
+			return err
+		}
+		session.Release()
+		fmt.Printf("Dagger engine '%v' is ready in namespace '%v'\n", pod.Name, kubeNamespace)
+		return nil
+
+	case "down":
+		err = withEngineLease(ctx, clientset, func(ctx context.Context) error {
+			return removeEngine(ctx, clientset, *volumes)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Dagger engine removed from namespace '%v'\n", kubeNamespace)
+		return nil
+
+	case "logs":
+		opts := &v1.PodLogOptions{Container: engineName, Follow: *follow}
+		if *tail >= 0 {
+			opts.TailLines = tail
+		}
+		logs, err := clientset.CoreV1().Pods(kubeNamespace).GetLogs(enginePodName(*ordinal), opts).Stream(ctx)
+		if err != nil {
+			return err
+		}
+		defer logs.Close()
+		_, err = io.Copy(os.Stdout, logs)
+		return err
+
+	default:
+		return fmt.Errorf("invalid engine command '%v'", os.Args[2])
+	}
+}
+
+// engineStatus prints the state of the engine pool, and of its pods and their
+// volumes.
+func engineStatus(ctx context.Context, clientset kubernetes.Interface) error {
+	sts, err := clientset.AppsV1().StatefulSets(kubeNamespace).Get(ctx, engineName, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		fmt.Printf("No Dagger engine in namespace '%v'\n", kubeNamespace)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintf(w, "Namespace:\t%v\n", kubeNamespace)
+	for _, c := range sts.Spec.Template.Spec.Containers {
+		if c.Name == engineName {
+			fmt.Fprintf(w, "Image:\t%v\n", c.Image)
+		}
+	}
+	fmt.Fprintf(w, "Ready:\t%d/%d\n", sts.Status.ReadyReplicas, *sts.Spec.Replicas)
+	fmt.Fprintf(w, "Age:\t%v\n", time.Since(sts.CreationTimestamp.Time).Round(time.Second))
+
+	if holder, ok := sts.Annotations[engineHolderAnnotation]; ok {
+		fmt.Fprintf(w, "Last used by:\t%v\n", holder)
+	}
+	idle, ttl := engineIdle(sts.Annotations)
+	fmt.Fprintf(w, "Idle:\t%v\n", idle.Round(time.Second))
+	if ttl > 0 {
+		fmt.Fprintf(w, "TTL:\t%v\n", ttl)
+	} else {
+		fmt.Fprintf(w, "TTL:\tnone\n")
+	}
+
+	sessions, err := activeSessions(ctx, clientset)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Pool:\t%d/%d engines\n", *sts.Spec.Replicas, enginePoolMax)
+	w.Flush()
+
+	// engines of the pool, with their volumes
+	fmt.Println()
+	pods, err := clientset.CoreV1().Pods(kubeNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: metav1.FormatLabelSelector(&metav1.LabelSelector{MatchLabels: engineLabels()}),
+	})
+	if err != nil {
+		return err
+	}
+	pvcs, err := clientset.CoreV1().PersistentVolumeClaims(kubeNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: metav1.FormatLabelSelector(&metav1.LabelSelector{MatchLabels: engineLabels()}),
+	})
+	if err != nil {
+		return err
+	}
+	volumes := map[string]v1.PersistentVolumeClaim{}
+	for _, pvc := range pvcs.Items {
+		volumes[pvc.Name] = pvc
+	}
+
+	fmt.Fprintf(w, "POD\tSTATUS\tSESSIONS\tRESTARTS\tNODE\tVOLUME\n")
+	for _, pod := range pods.Items {
+		status := string(pod.Status.Phase)
+		restarts := int32(0)
+		for _, cs := range pod.Status.ContainerStatuses {
+			if waiting := cs.State.Waiting; waiting != nil {
+				status = waiting.Reason
+			}
+			restarts += cs.RestartCount
+		}
+		volume := "none"
+		if pvc, ok := volumes[engineDataVolume+"-"+pod.Name]; ok {
+			size := pvc.Spec.Resources.Requests[v1.ResourceStorage]
+			volume = fmt.Sprintf("%v (%v, %v)", pvc.Name, size.String(), pvc.Status.Phase)
+		}
+		fmt.Fprintf(w, "%v\t%v\t%d\t%d\t%v\t%v\n", pod.Name, status, sessions[pod.Name], restarts, pod.Spec.NodeName, volume)
+	}
+	return nil
+}
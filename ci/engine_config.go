@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+// EngineConfig is the scheduling and resources of the remote engine pod.
+// It is read from the file given by --engine-config, using the field names of
+// the Kubernetes pod spec, and individual fields are overridden by flags.
+type EngineConfig struct {
+	// Resources of the engine container.  Requests equal to limits give the
+	// engine guaranteed CPU and memory.
+	Resources v1.ResourceRequirements `json:"resources,omitempty"`
+
+	// NodeSelector constrains the nodes the engine is scheduled on.
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Tolerations of the engine, e.g. of the taints of a dedicated node pool.
+	Tolerations []v1.Toleration `json:"tolerations,omitempty"`
+
+	// Affinity of the engine.
+	Affinity *v1.Affinity `json:"affinity,omitempty"`
+
+	// ServiceAccountName of the engine pod.
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// ImagePullSecrets pulling the engine image.
+	ImagePullSecrets []string `json:"imagePullSecrets,omitempty"`
+}
+
+// engine config flags, overriding the config file when set
+var (
+	engineConfigFile     string
+	engineCPU            string
+	engineMemory         string
+	engineNodeSelector   string
+	engineTolerations    string
+	engineServiceAccount string
+	enginePullSecrets    string
+)
+
+// engineConfigGiven returns whether the config of the engine was given, by
+// the config file or any of the flags.
+func engineConfigGiven() bool {
+	for _, v := range []string{engineConfigFile, engineCPU, engineMemory, engineNodeSelector, engineTolerations, engineServiceAccount, enginePullSecrets} {
+		if v != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// loadEngineConfig returns the config of the engine from the config file, if
+// any, overridden by the flags which are set.
+func loadEngineConfig() (EngineConfig, error) {
+	cfg := EngineConfig{}
+	if engineConfigFile != "" {
+		bb, err := os.ReadFile(engineConfigFile)
+		if err != nil {
+			return cfg, err
+		}
+		if err = yaml.UnmarshalStrict(bb, &cfg); err != nil {
+			return cfg, fmt.Errorf("engine config '%v': %w", engineConfigFile, err)
+		}
+	}
+
+	for name, value := range map[v1.ResourceName]string{v1.ResourceCPU: engineCPU, v1.ResourceMemory: engineMemory} {
+		if value == "" {
+			continue
+		}
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			return cfg, fmt.Errorf("engine %v '%v': %w", name, value, err)
+		}
+		if cfg.Resources.Requests == nil {
+			cfg.Resources.Requests = v1.ResourceList{}
+		}
+		if cfg.Resources.Limits == nil {
+			cfg.Resources.Limits = v1.ResourceList{}
+		}
+		cfg.Resources.Requests[name] = q
+		cfg.Resources.Limits[name] = q
+	}
+
+	if engineNodeSelector != "" {
+		cfg.NodeSelector = map[string]string{}
+		for _, kv := range strings.Split(engineNodeSelector, ",") {
+			k, v, ok := strings.Cut(strings.TrimSpace(kv), "=")
+			if !ok || k == "" {
+				return cfg, fmt.Errorf("node selector '%v' is not of the form key=value", kv)
+			}
+			cfg.NodeSelector[k] = v
+		}
+	}
+
+	if engineTolerations != "" {
+		cfg.Tolerations = nil
+		for _, t := range strings.Split(engineTolerations, ",") {
+			toleration, err := parseToleration(strings.TrimSpace(t))
+			if err != nil {
+				return cfg, err
+			}
+			cfg.Tolerations = append(cfg.Tolerations, toleration)
+		}
+	}
+
+	if engineServiceAccount != "" {
+		cfg.ServiceAccountName = engineServiceAccount
+	}
+	if enginePullSecrets != "" {
+		cfg.ImagePullSecrets = nil
+		for _, s := range strings.Split(enginePullSecrets, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				cfg.ImagePullSecrets = append(cfg.ImagePullSecrets, s)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// parseToleration parses a toleration of the form key[=value][:effect], as
+// shown by kubectl for taints.  Without a value, any value is tolerated.
+func parseToleration(s string) (v1.Toleration, error) {
+	t := v1.Toleration{Operator: v1.TolerationOpExists}
+	rest, effect, hasEffect := strings.Cut(s, ":")
+	if hasEffect {
+		t.Effect = v1.TaintEffect(effect)
+		switch t.Effect {
+		case v1.TaintEffectNoSchedule, v1.TaintEffectPreferNoSchedule, v1.TaintEffectNoExecute:
+		default:
+			return t, fmt.Errorf("toleration '%v' has an invalid effect '%v'", s, effect)
+		}
+	}
+	key, value, hasValue := strings.Cut(rest, "=")
+	if key == "" {
+		return t, fmt.Errorf("toleration '%v' has no key", s)
+	}
+	t.Key = key
+	if hasValue {
+		t.Operator = v1.TolerationOpEqual
+		t.Value = value
+	}
+	return t, nil
+}
+
+// engineConfigOf returns the config of the engine pod
+func engineConfigOf(spec *v1.PodSpec) EngineConfig {
+	cfg := EngineConfig{
+		NodeSelector:       spec.NodeSelector,
+		Tolerations:        spec.Tolerations,
+		Affinity:           spec.Affinity,
+		ServiceAccountName: spec.ServiceAccountName,
+	}
+	for _, c := range spec.Containers {
+		if c.Name == engineName {
+			cfg.Resources = c.Resources
+		}
+	}
+	for _, s := range spec.ImagePullSecrets {
+		cfg.ImagePullSecrets = append(cfg.ImagePullSecrets, s.Name)
+	}
+	return cfg
+}
+
+// apply sets the scheduling and resources of the config on the engine pod
+func (cfg EngineConfig) apply(spec *v1.PodSpec) {
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == engineName {
+			spec.Containers[i].Resources = cfg.Resources
+		}
+	}
+	spec.NodeSelector = cfg.NodeSelector
+	spec.Tolerations = cfg.Tolerations
+	spec.Affinity = cfg.Affinity
+	spec.ServiceAccountName = cfg.ServiceAccountName
+	spec.ImagePullSecrets = nil
+	for _, s := range cfg.ImagePullSecrets {
+		spec.ImagePullSecrets = append(spec.ImagePullSecrets, v1.LocalObjectReference{Name: s})
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestParseToleration(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    v1.Toleration
+		wantErr bool
+	}{
+		{
+			in:   "dedicated=ci:NoSchedule",
+			want: v1.Toleration{Key: "dedicated", Operator: v1.TolerationOpEqual, Value: "ci", Effect: v1.TaintEffectNoSchedule},
+		},
+		{
+			in:   "dedicated=:NoExecute",
+			want: v1.Toleration{Key: "dedicated", Operator: v1.TolerationOpEqual, Effect: v1.TaintEffectNoExecute},
+		},
+		{
+			in:   "dedicated:PreferNoSchedule",
+			want: v1.Toleration{Key: "dedicated", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectPreferNoSchedule},
+		},
+		{
+			in:   "dedicated=ci",
+			want: v1.Toleration{Key: "dedicated", Operator: v1.TolerationOpEqual, Value: "ci"},
+		},
+		{
+			in:   "dedicated",
+			want: v1.Toleration{Key: "dedicated", Operator: v1.TolerationOpExists},
+		},
+		{in: "dedicated=ci:NoRun", wantErr: true},
+		{in: "dedicated:", wantErr: true},
+		{in: "=ci:NoSchedule", wantErr: true},
+		{in: ":NoSchedule", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseToleration(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseToleration(%q) = %+v, expected an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseToleration(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseToleration(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	rbacv1ac "k8s.io/client-go/applyconfigurations/rbac/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// engineRBACName is the name of the ServiceAccount, Role and RoleBinding
+	// of the pipelines running remote builds.
+	engineRBACName = "dagger-knative"
+
+	// podSecurityLevel is the Pod Security Standard the namespace of the
+	// engine must allow, as the engine runs privileged.
+	podSecurityLevel = "privileged"
+
+	podSecurityEnforceLabel = "pod-security.kubernetes.io/enforce"
+)
+
+// engineRule is a permission remote builds need in the namespace of the
+// engine.  The resource may include a subresource, e.g. pods/portforward.
+type engineRule struct {
+	group    string
+	resource string
+	verbs    []string
+}
+
+// engineRules are the permissions remote builds need, granted by the Role
+// created by engine install and checked before building.
+var engineRules = []engineRule{
+	{"apps", "statefulsets", []string{"get", "create", "update", "patch", "delete"}},
+	{"", "services", []string{"get", "create", "update", "delete"}},
+	{"", "pods", []string{"get", "list", "watch", "delete"}},
+	{"", "pods/portforward", []string{"create"}},
+	{"", "pods/log", []string{"get"}},
+	{"", "persistentvolumeclaims", []string{"list", "deletecollection"}},
+	{"", "events", []string{"list"}},
+	{"coordination.k8s.io", "leases", []string{"get", "list", "create", "update", "delete"}},
+}
+
+var (
+	// bindUsers are the users bound to the Role of remote builds
+	bindUsers string
+
+	// bindGroups are the groups bound to the Role of remote builds
+	bindGroups string
+)
+
+// installEngine creates the namespace of the engine, labelled to allow the
+// privileged engine, and the ServiceAccount, Role and RoleBinding of the
+// pipelines running remote builds.  Pipelines running in the cluster use the
+// ServiceAccount, and the Role is also bound to the given users and groups,
+// including the service accounts of other namespaces as users
+// (system:serviceaccount:<namespace>:<name>).  Existing resources are updated.
+func installEngine(ctx context.Context, clientset kubernetes.Interface) error {
+	opts := metav1.ApplyOptions{FieldManager: fieldManager, Force: true}
+
+	_, err := clientset.CoreV1().Namespaces().Apply(ctx, corev1ac.Namespace(kubeNamespace).
+		WithLabels(map[string]string{
+			podSecurityEnforceLabel:            podSecurityLevel,
+			"pod-security.kubernetes.io/audit": podSecurityLevel,
+			"pod-security.kubernetes.io/warn":  podSecurityLevel,
+		}), opts)
+	if err != nil {
+		return fmt.Errorf("namespace '%v': %w", kubeNamespace, err)
+	}
+	fmt.Printf("Namespace '%v' allows %v pods\n", kubeNamespace, podSecurityLevel)
+
+	_, err = clientset.CoreV1().ServiceAccounts(kubeNamespace).Apply(ctx, corev1ac.ServiceAccount(engineRBACName, kubeNamespace).
+		WithLabels(engineLabels()), opts)
+	if err != nil {
+		return fmt.Errorf("service account '%v': %w", engineRBACName, err)
+	}
+
+	role := rbacv1ac.Role(engineRBACName, kubeNamespace).WithLabels(engineLabels())
+	for _, r := range engineRules {
+		role = role.WithRules(rbacv1ac.PolicyRule().
+			WithAPIGroups(r.group).
+			WithResources(r.resource).
+			WithVerbs(r.verbs...))
+	}
+	if _, err = clientset.RbacV1().Roles(kubeNamespace).Apply(ctx, role, opts); err != nil {
+		return fmt.Errorf("role '%v': %w", engineRBACName, err)
+	}
+
+	binding := rbacv1ac.RoleBinding(engineRBACName, kubeNamespace).
+		WithLabels(engineLabels()).
+		WithRoleRef(rbacv1ac.RoleRef().
+			WithAPIGroup("rbac.authorization.k8s.io").
+			WithKind("Role").
+			WithName(engineRBACName)).
+		WithSubjects(rbacv1ac.Subject().
+			WithKind("ServiceAccount").
+			WithName(engineRBACName).
+			WithNamespace(kubeNamespace))
+	for _, u := range splitList(bindUsers) {
+		binding = binding.WithSubjects(rbacv1ac.Subject().
+			WithKind("User").
+			WithAPIGroup("rbac.authorization.k8s.io").
+			WithName(u))
+	}
+	for _, g := range splitList(bindGroups) {
+		binding = binding.WithSubjects(rbacv1ac.Subject().
+			WithKind("Group").
+			WithAPIGroup("rbac.authorization.k8s.io").
+			WithName(g))
+	}
+	if _, err = clientset.RbacV1().RoleBindings(kubeNamespace).Apply(ctx, binding, opts); err != nil {
+		return fmt.Errorf("role binding '%v': %w", engineRBACName, err)
+	}
+	subjects := append([]string{"service account '" + engineRBACName + "'"}, splitList(bindUsers)...)
+	subjects = append(subjects, splitList(bindGroups)...)
+	fmt.Printf("%v may run remote builds in namespace '%v'\n", strings.Join(subjects, ", "), kubeNamespace)
+	return nil
+}
+
+// preflightEngine checks the namespace of the engine exists and allows the
+// privileged engine, and that the current user has the permissions remote
+// builds need, explaining what is missing otherwise.
+func preflightEngine(ctx context.Context, clientset kubernetes.Interface) error {
+	ns, err := clientset.CoreV1().Namespaces().Get(ctx, kubeNamespace, metav1.GetOptions{})
+	switch {
+	case kerr.IsNotFound(err):
+		return fmt.Errorf("namespace '%v' does not exist, create it for remote builds with 'engine install --kube-namespace %v'", kubeNamespace, kubeNamespace)
+	case kerr.IsForbidden(err):
+		// namespaces can not be read by every user, leave it to the build
+	case err != nil:
+		return err
+	default:
+		if level, ok := ns.Labels[podSecurityEnforceLabel]; ok && level != podSecurityLevel {
+			return fmt.Errorf("namespace '%v' enforces the '%v' pod security level, while the Dagger engine runs privileged; label it with 'engine install --kube-namespace %v'", kubeNamespace, level, kubeNamespace)
+		}
+	}
+
+	var missing []string
+	for _, r := range engineRules {
+		for _, verb := range r.verbs {
+			allowed, err := accessAllowed(ctx, clientset, r, verb)
+			if err != nil {
+				return fmt.Errorf("checking permissions: %w", err)
+			}
+			if !allowed {
+				missing = append(missing, "  "+verb+" "+qualifiedResource(r))
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing permissions for remote builds in namespace '%v':\n%v\nask a cluster admin to grant them with 'engine install --kube-namespace %v --bind-user <user>'",
+			kubeNamespace, strings.Join(missing, "\n"), kubeNamespace)
+	}
+	return nil
+}
+
+// accessAllowed returns whether the current user may use the verb on the
+// resource of the rule in the namespace of the engine.
+func accessAllowed(ctx context.Context, clientset kubernetes.Interface, r engineRule, verb string) (bool, error) {
+	resource, subresource, _ := strings.Cut(r.resource, "/")
+	review, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace:   kubeNamespace,
+				Verb:        verb,
+				Group:       r.group,
+				Resource:    resource,
+				Subresource: subresource,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return false, err
+	}
+	return review.Status.Allowed, nil
+}
+
+// qualifiedResource returns the resource of the rule qualified by its group
+func qualifiedResource(r engineRule) string {
+	if r.group == "" {
+		return r.resource
+	}
+	return r.resource + "." + r.group
+}
+
+// splitList splits a comma separated list, omitting empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
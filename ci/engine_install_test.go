@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestPreflightEngine(t *testing.T) {
+	var total int
+	for _, r := range engineRules {
+		total += len(r.verbs)
+	}
+	tests := []struct {
+		name    string
+		denied  map[string]bool // denied reviews, as verb resource.group
+		missing []string
+	}{
+		{name: "all permissions"},
+		{name: "denied", denied: map[string]bool{"delete pods": true, "update services": true}, missing: []string{"delete pods", "update services"}},
+		{name: "denied subresource", denied: map[string]bool{"create pods/portforward": true}, missing: []string{"create pods/portforward"}},
+		{name: "denied group", denied: map[string]bool{"update leases.coordination.k8s.io": true}, missing: []string{"update leases.coordination.k8s.io"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:   kubeNamespace,
+				Labels: map[string]string{podSecurityEnforceLabel: podSecurityLevel},
+			}})
+			reviewed := map[string]bool{}
+			clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+				attrs := review.Spec.ResourceAttributes
+				r := engineRule{group: attrs.Group, resource: attrs.Resource}
+				if attrs.Subresource != "" {
+					r.resource += "/" + attrs.Subresource
+				}
+				if attrs.Namespace != kubeNamespace {
+					t.Errorf("review of %v in namespace %q, want %q", qualifiedResource(r), attrs.Namespace, kubeNamespace)
+				}
+				permission := attrs.Verb + " " + qualifiedResource(r)
+				reviewed[permission] = true
+				return true, &authorizationv1.SelfSubjectAccessReview{Status: authorizationv1.SubjectAccessReviewStatus{Allowed: !tt.denied[permission]}}, nil
+			})
+
+			err := preflightEngine(context.Background(), clientset)
+			if len(reviewed) != total {
+				t.Errorf("reviewed %d permissions, want one review per verb of engineRules (%d)", len(reviewed), total)
+			}
+			if len(tt.missing) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected missing permissions")
+			}
+			for _, permission := range tt.missing {
+				if !strings.Contains(err.Error(), "  "+permission+"\n") {
+					t.Errorf("error does not list %q as missing: %v", permission, err)
+				}
+			}
+			if n := strings.Count(err.Error(), "\n  "); n != len(tt.missing) {
+				t.Errorf("%d permissions missing, want %d: %v", n, len(tt.missing), err)
+			}
+		})
+	}
+}
+
+func TestInstallEngineBindsServiceAccount(t *testing.T) {
+	defer func(users, groups string) { bindUsers, bindGroups = users, groups }(bindUsers, bindGroups)
+	bindUsers, bindGroups = "alice", ""
+
+	clientset := fake.NewSimpleClientset()
+	applied := map[string]string{}
+	clientset.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		applied[patch.GetResource().Resource] = string(patch.GetPatch())
+		return true, nil, nil
+	})
+	if err := installEngine(context.Background(), clientset); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, resource := range []string{"namespaces", "serviceaccounts", "roles", "rolebindings"} {
+		if _, ok := applied[resource]; !ok {
+			t.Errorf("%v not applied", resource)
+		}
+	}
+	binding := applied["rolebindings"]
+	for _, subject := range []string{`"kind":"ServiceAccount","name":"` + engineRBACName + `","namespace":"` + kubeNamespace + `"`, `"kind":"User","apiGroup":"rbac.authorization.k8s.io","name":"alice"`} {
+		if !strings.Contains(binding, subject) {
+			t.Errorf("role binding %v does not bind %v", binding, subject)
+		}
+	}
+}
+
+// clientsetGroups are the API groups of the typed clients of the clientset
+var clientsetGroups = map[string]string{"CoreV1": "", "AppsV1": "apps", "CoordinationV1": "coordination.k8s.io"}
+
+// clientsetVerbs are the verbs of the methods of the typed clients
+var clientsetVerbs = map[string]string{
+	"Get":              "get",
+	"List":             "list",
+	"Watch":            "watch",
+	"Create":           "create",
+	"Update":           "update",
+	"Patch":            "patch",
+	"Apply":            "patch",
+	"Delete":           "delete",
+	"DeleteCollection": "deletecollection",
+}
+
+// clientsetUse is a verb used on a resource through the typed clients of the
+// clientset, by a function at a position of the sources.
+type clientsetUse struct {
+	group, resource, verb string
+	fn                    string
+	pos                   token.Position
+}
+
+// clientsetUses returns the verbs used on resources through the typed
+// clients of the clientset in the non-test sources of the package.  Clients
+// assigned to a variable (e.g. leases) are followed.
+func clientsetUses(t *testing.T) []clientsetUse {
+	t.Helper()
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// resourceOf returns the group and resource of a typed client, given as
+	// e.g. clientset.AppsV1().StatefulSets(namespace)
+	resourceOf := func(expr ast.Expr) (clientsetUse, bool) {
+		call, ok := expr.(*ast.CallExpr)
+		if !ok {
+			return clientsetUse{}, false
+		}
+		resource, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return clientsetUse{}, false
+		}
+		version, ok := resource.X.(*ast.CallExpr)
+		if !ok {
+			return clientsetUse{}, false
+		}
+		group, ok := version.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return clientsetUse{}, false
+		}
+		name, ok := clientsetGroups[group.Sel.Name]
+		return clientsetUse{group: name, resource: strings.ToLower(resource.Sel.Name)}, ok
+	}
+
+	var uses []clientsetUse
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients := map[*ast.Object]clientsetUse{}
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+			ast.Inspect(fn, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.AssignStmt:
+					for i, rhs := range n.Rhs {
+						if id, ok := n.Lhs[i].(*ast.Ident); ok && id.Obj != nil && len(n.Lhs) == len(n.Rhs) {
+							if client, ok := resourceOf(rhs); ok {
+								clients[id.Obj] = client
+							}
+						}
+					}
+				case *ast.CallExpr:
+					method, ok := n.Fun.(*ast.SelectorExpr)
+					if !ok {
+						return true
+					}
+					client, ok := resourceOf(method.X)
+					if id, isIdent := method.X.(*ast.Ident); isIdent && id.Obj != nil {
+						client, ok = clients[id.Obj]
+					}
+					if !ok {
+						return true
+					}
+					if client.verb, ok = clientsetVerbs[method.Sel.Name]; !ok && client.resource == "pods" && method.Sel.Name == "GetLogs" {
+						client.resource, client.verb, ok = "pods/log", "get", true
+					}
+					if ok {
+						client.fn, client.pos = fn.Name.Name, fset.Position(n.Pos())
+						uses = append(uses, client)
+					}
+				}
+				return true
+			})
+		}
+	}
+	return uses
+}
+
+// TestEngineRulesCoverClientset ensures the permissions granted by engine
+// install and checked before building cover every verb used on a resource by
+// the engine code.  engine install itself is run by a cluster admin, and the
+// preflight tolerates reading the namespace being forbidden.
+func TestEngineRulesCoverClientset(t *testing.T) {
+	granted := map[string]bool{}
+	for _, r := range engineRules {
+		for _, verb := range r.verbs {
+			granted[r.group+"/"+r.resource+":"+verb] = true
+		}
+	}
+
+	uses := clientsetUses(t)
+	if len(uses) < 20 {
+		t.Fatalf("found %d uses of the clientset, expected the engine code to be scanned", len(uses))
+	}
+	for _, use := range uses {
+		if use.fn == "installEngine" || (use.fn == "preflightEngine" && use.resource == "namespaces") {
+			continue
+		}
+		if !granted[use.group+"/"+use.resource+":"+use.verb] {
+			r := engineRule{group: use.group, resource: use.resource}
+			t.Errorf("%v: %v %v is not in engineRules", use.pos, use.verb, qualifiedResource(r))
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// engineLeaseDuration is the time after which the Lease of an invocation
+	// which did not release it (e.g. was killed) expires.
+	engineLeaseDuration = 2 * time.Minute
+
+	// engineLeaseRetryInterval is the interval at which a held Lease is
+	// retried.
+	engineLeaseRetryInterval = 2 * time.Second
+
+	// engineHolderAnnotation is the annotation of the engine holding the
+	// pipeline which last acquired it.
+	engineHolderAnnotation = "dagger-knative/last-used-by"
+)
+
+// engineLeaseRenewInterval is the interval at which the Lease is renewed
+// while held, well within its duration such that it never expires while the
+// engine is, for instance, waited for to be deleted.
+var engineLeaseRenewInterval = engineLeaseDuration / 4
+
+// errEngineLeaseLost indicates the Lease was taken over while held
+var errEngineLeaseLost = errors.New("Dagger engine lease lost to another invocation")
+
+// pipelineID returns the identity of this invocation: the CI job when run by
+// a known CI system, and the host and process, which tell apart concurrent
+// jobs of the same CI run.
+func pipelineID() string {
+	host, _ := os.Hostname()
+	process := fmt.Sprintf("%v/%d", host, os.Getpid())
+	for _, env := range [][]string{
+		{"GITHUB_REPOSITORY", "GITHUB_RUN_ID"}, // GitHub Actions
+		{"CI_PROJECT_PATH", "CI_JOB_ID"},       // GitLab CI
+		{"JOB_NAME", "BUILD_ID"},               // Jenkins
+		{"BUILDKITE_PIPELINE_SLUG", "BUILDKITE_BUILD_ID"},
+	} {
+		name, id := os.Getenv(env[0]), os.Getenv(env[1])
+		if name != "" && id != "" {
+			return fmt.Sprintf("%v/%v (%v)", name, id, process)
+		}
+	}
+	return process
+}
+
+// withEngineLease runs fn while holding the coordination Lease of the engine,
+// such that concurrent invocations do not race creating, replacing or
+// removing it.  A Lease held by another invocation is waited for until it is
+// released or expires, or the context is done.  The Lease is renewed while
+// fn runs, and the context of fn is canceled should it be lost regardless.
+func withEngineLease(ctx context.Context, clientset kubernetes.Interface, fn func(ctx context.Context) error) error {
+	holder := pipelineID()
+	lease, err := acquireEngineLease(ctx, clientset, holder)
+	if err != nil {
+		return err
+	}
+
+	held, cancel := context.WithCancelCause(ctx)
+	renewed := make(chan *coordinationv1.Lease, 1)
+	go func() {
+		renewed <- renewEngineLease(held, clientset, lease, cancel)
+	}()
+
+	err = fn(held)
+	if cause := context.Cause(held); err != nil && errors.Is(cause, errEngineLeaseLost) {
+		err = fmt.Errorf("%w: %v", cause, err)
+	}
+	cancel(nil)
+	if lease = <-renewed; lease != nil {
+		releaseEngineLease(clientset, lease)
+	}
+	return err
+}
+
+// renewEngineLease renews the held Lease until the context is done, returning
+// the Lease as last renewed.  Should the Lease be taken over, lost is called
+// and renewing stops, returning nil as there is nothing left to release.
+// Failing to renew it is retried, as the Lease remains held until it expires.
+func renewEngineLease(ctx context.Context, clientset kubernetes.Interface, lease *coordinationv1.Lease, lost context.CancelCauseFunc) *coordinationv1.Lease {
+	leases := clientset.CoordinationV1().Leases(kubeNamespace)
+	holder := leaseHolder(lease)
+	ticker := time.NewTicker(engineLeaseRenewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return lease
+		case <-ticker.C:
+		}
+
+		current, err := leases.Get(ctx, lease.Name, metav1.GetOptions{})
+		if err == nil && leaseHolder(current) != holder {
+			fmt.Printf("Warning: Dagger engine lease taken over by %v\n", leaseHolder(current))
+			lost(errEngineLeaseLost)
+			return nil
+		}
+		if err == nil {
+			now := metav1.NewMicroTime(time.Now())
+			current.Spec.RenewTime = &now
+			current, err = leases.Update(ctx, current, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Println("Warning: unable to renew the Dagger engine lease:", err)
+			}
+			continue
+		}
+		lease = current
+	}
+}
+
+// acquireEngineLease returns the engine Lease once held by holder
+func acquireEngineLease(ctx context.Context, clientset kubernetes.Interface, holder string) (*coordinationv1.Lease, error) {
+	leases := clientset.CoordinationV1().Leases(kubeNamespace)
+	duration := int32(engineLeaseDuration.Seconds())
+	waiting := ""
+
+	for {
+		now := metav1.NewMicroTime(time.Now())
+		lease, err := leases.Get(ctx, engineName, metav1.GetOptions{})
+		switch {
+		case kerr.IsNotFound(err):
+			lease, err = leases.Create(ctx, &coordinationv1.Lease{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   engineName,
+					Labels: engineLabels(),
+				},
+				Spec: coordinationv1.LeaseSpec{
+					HolderIdentity:       &holder,
+					LeaseDurationSeconds: &duration,
+					AcquireTime:          &now,
+					RenewTime:            &now,
+				},
+			}, metav1.CreateOptions{})
+			if err == nil {
+				return lease, nil
+			}
+			if !kerr.IsAlreadyExists(err) {
+				return nil, err
+			}
+
+		case err != nil:
+			return nil, err
+
+		default:
+			current := leaseHolder(lease)
+			if current == "" || current == holder || leaseExpired(lease) {
+				lease.Spec.HolderIdentity = &holder
+				lease.Spec.LeaseDurationSeconds = &duration
+				lease.Spec.AcquireTime = &now
+				lease.Spec.RenewTime = &now
+				lease, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+				if err == nil {
+					return lease, nil
+				}
+				if !kerr.IsConflict(err) {
+					return nil, err
+				}
+			} else if current != waiting {
+				fmt.Printf("Waiting for the Dagger engine, held by %v\n", current)
+				waiting = current
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for the Dagger engine lease: %w", ctx.Err())
+		case <-time.After(engineLeaseRetryInterval):
+		}
+	}
+}
+
+// releaseEngineLease releases the Lease, unless it was taken over since.
+// Failing to release it only delays other invocations until it expires.
+func releaseEngineLease(clientset kubernetes.Interface, lease *coordinationv1.Lease) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	lease.Spec.HolderIdentity = nil
+	lease.Spec.AcquireTime = nil
+	lease.Spec.RenewTime = nil
+	if _, err := clientset.CoordinationV1().Leases(kubeNamespace).Update(ctx, lease, metav1.UpdateOptions{}); err != nil && !kerr.IsConflict(err) {
+		fmt.Println("Warning: unable to release the Dagger engine lease:", err)
+	}
+}
+
+// leaseHolder returns the holder of the Lease, if any
+func leaseHolder(lease *coordinationv1.Lease) string {
+	if lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
+
+// leaseExpired returns whether the Lease was not renewed within its duration
+func leaseExpired(lease *coordinationv1.Lease) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return time.Now().After(expiry)
+}
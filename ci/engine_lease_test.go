@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// heldLease returns the engine Lease held by holder, last renewed at renewed
+func heldLease(holder string, renewed time.Time) *coordinationv1.Lease {
+	duration := int32(engineLeaseDuration.Seconds())
+	renewTime := metav1.NewMicroTime(renewed)
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: engineName, Namespace: kubeNamespace},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &duration,
+			AcquireTime:          &renewTime,
+			RenewTime:            &renewTime,
+		},
+	}
+}
+
+func TestAcquireEngineLease(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing *coordinationv1.Lease
+		wantErr  bool
+	}{
+		{name: "no lease"},
+		{name: "released lease", existing: &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: engineName, Namespace: kubeNamespace}}},
+		{name: "held by itself", existing: heldLease("me", time.Now())},
+		{name: "expired lease of another", existing: heldLease("other", time.Now().Add(-2*engineLeaseDuration))},
+		{name: "held by another", existing: heldLease("other", time.Now()), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			if tt.existing != nil {
+				clientset = fake.NewSimpleClientset(tt.existing)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			lease, err := acquireEngineLease(ctx, clientset, "me")
+			if tt.wantErr {
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Fatalf("expected to wait for the lease until the deadline, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if holder := leaseHolder(lease); holder != "me" {
+				t.Errorf("holder = %q, want me", holder)
+			}
+			if leaseExpired(lease) {
+				t.Error("acquired lease is expired")
+			}
+		})
+	}
+}
+
+func TestReleaseEngineLease(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset()
+	lease, err := acquireEngineLease(ctx, clientset, "me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseEngineLease(clientset, lease)
+
+	// another invocation acquires it without waiting
+	ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if lease, err = acquireEngineLease(ctx, clientset, "other"); err != nil {
+		t.Fatal(err)
+	}
+	if holder := leaseHolder(lease); holder != "other" {
+		t.Errorf("holder = %q, want other", holder)
+	}
+}
+
+func TestWithEngineLeaseRenews(t *testing.T) {
+	defer func(interval time.Duration) { engineLeaseRenewInterval = interval }(engineLeaseRenewInterval)
+	engineLeaseRenewInterval = 10 * time.Millisecond
+
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset()
+	leases := clientset.CoordinationV1().Leases(kubeNamespace)
+
+	err := withEngineLease(ctx, clientset, func(ctx context.Context) error {
+		acquired, err := leases.Get(ctx, engineName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		time.Sleep(10 * engineLeaseRenewInterval)
+		renewed, err := leases.Get(ctx, engineName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if !renewed.Spec.RenewTime.After(acquired.Spec.RenewTime.Time) {
+			t.Errorf("lease not renewed while held: renewed at %v, acquired at %v", renewed.Spec.RenewTime, acquired.Spec.RenewTime)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lease, err := leases.Get(ctx, engineName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder := leaseHolder(lease); holder != "" {
+		t.Errorf("lease held by %q once done, want it released", holder)
+	}
+}
+
+func TestWithEngineLeaseLost(t *testing.T) {
+	defer func(interval time.Duration) { engineLeaseRenewInterval = interval }(engineLeaseRenewInterval)
+	engineLeaseRenewInterval = 10 * time.Millisecond
+
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset()
+	leases := clientset.CoordinationV1().Leases(kubeNamespace)
+
+	err := withEngineLease(ctx, clientset, func(ctx context.Context) error {
+		// taken over, as if it had expired
+		if _, err := leases.Update(ctx, heldLease("other", time.Now()), metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+			return nil
+		}
+	})
+	if !errors.Is(err, errEngineLeaseLost) {
+		t.Fatalf("expected the lease to be lost, got %v", err)
+	}
+
+	lease, err := leases.Get(ctx, engineName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder := leaseHolder(lease); holder != "other" {
+		t.Errorf("lease held by %q, want it left to the invocation which took it over", holder)
+	}
+}
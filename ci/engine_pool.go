@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// engineSessionName is the name of the session Leases, and of the label
+	// identifying them.
+	engineSessionName = "dagger-session"
+
+	// engineSessionLabel is the label of a session Lease holding the pod of
+	// the engine it uses.
+	engineSessionLabel = "dagger-knative/engine-pod"
+
+	// engineSessionDuration is the time after which the session of an
+	// invocation which stopped renewing it (e.g. was killed) expires.
+	engineSessionDuration = 30 * time.Second
+)
+
+const (
+	// selectByHash selects the engine by consistent hashing of the function
+	// name, such that builds of a function reuse the caches of the engine.
+	selectByHash = "hash"
+
+	// selectByLoad selects the engine with the fewest active sessions.
+	selectByLoad = "load"
+)
+
+var (
+	// enginePoolMax is the maximum number of engines the pool is scaled to
+	enginePoolMax int
+
+	// engineSessionsMax is the number of active sessions from which an
+	// engine is busy, and further sessions go to another engine of the pool
+	engineSessionsMax int
+
+	// engineSelection is how an engine of the pool is selected
+	engineSelection string
+)
+
+// engineSession is an active session of this invocation on an engine of the
+// pool, recorded as a Lease renewed until released.
+type engineSession struct {
+	pod  string
+	stop chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+
+	// stopForward is closed to stop forwarding to the engine
+	stopForward chan struct{}
+}
+
+// enginePodName is the name of the pod of the engine's StatefulSet with the
+// given ordinal.
+func enginePodName(ordinal int) string {
+	return engineName + "-" + strconv.Itoa(ordinal)
+}
+
+// activeSessions returns the number of unexpired sessions by engine pod.
+// Expired sessions, of invocations killed before ending them, are deleted.
+func activeSessions(ctx context.Context, clientset kubernetes.Interface) (map[string]int, error) {
+	leases := clientset.CoordinationV1().Leases(kubeNamespace)
+	list, err := leases.List(ctx, metav1.ListOptions{
+		LabelSelector: "app.kubernetes.io/name=" + engineSessionName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	sessions := map[string]int{}
+	for i := range list.Items {
+		lease := &list.Items[i]
+		if !leaseExpired(lease) {
+			sessions[lease.Labels[engineSessionLabel]]++
+			continue
+		}
+		err = leases.Delete(ctx, lease.Name, metav1.DeleteOptions{})
+		if err != nil && !kerr.IsNotFound(err) {
+			return nil, err
+		}
+	}
+	return sessions, nil
+}
+
+// hashOrdinal returns the ordinal of the engine of a pool of n engines for
+// the key, by rendezvous hashing: growing the pool only moves the keys which
+// the new engine wins, such that the others keep their engine's caches.
+func hashOrdinal(key string, n int) int {
+	return hashRanking(key, n)[0]
+}
+
+// hashRanking returns the ordinals of a pool of n engines for the key, by
+// decreasing rendezvous hash score.  A key overflowing its busy engine thus
+// always overflows to the same next engine, keeping its caches there too.
+func hashRanking(key string, n int) []int {
+	scores := make([]uint64, n)
+	ordinals := make([]int, n)
+	for i := range ordinals {
+		h := sha256.Sum256([]byte(key + "/" + strconv.Itoa(i)))
+		scores[i] = binary.BigEndian.Uint64(h[:8])
+		ordinals[i] = i
+	}
+	sort.SliceStable(ordinals, func(i, j int) bool {
+		return scores[ordinals[i]] > scores[ordinals[j]]
+	})
+	return ordinals
+}
+
+// selectEngine returns the ordinal of the engine of the pool to use for the
+// key (the function name).  Engines are preferred in the key's rendezvous
+// hash order, or by load without a key, and the first one with fewer than
+// engineSessionsMax sessions is used.  Only once all are busy is the pool
+// scaled up by one, below its maximum, and the new engine used.  Must be
+// called holding the engine Lease.
+func selectEngine(ctx context.Context, clientset kubernetes.Interface, key string) (int, error) {
+	sts, err := clientset.AppsV1().StatefulSets(kubeNamespace).Get(ctx, engineName, metav1.GetOptions{})
+	if err != nil {
+		return 0, err
+	}
+	n := 1
+	if sts.Spec.Replicas != nil && *sts.Spec.Replicas > 1 {
+		n = int(*sts.Spec.Replicas)
+	}
+	sessions, err := activeSessions(ctx, clientset)
+	if err != nil {
+		return 0, err
+	}
+
+	var order []int
+	if engineSelection == selectByHash && key != "" {
+		order = hashRanking(key, n)
+	} else {
+		order = make([]int, n)
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			return sessions[enginePodName(order[i])] < sessions[enginePodName(order[j])]
+		})
+	}
+	for _, ordinal := range order {
+		if sessions[enginePodName(ordinal)] < engineSessionsMax {
+			if ordinal != order[0] {
+				fmt.Printf("Dagger engine %v is busy with %d sessions, using %v\n", enginePodName(order[0]), sessions[enginePodName(order[0])], enginePodName(ordinal))
+			}
+			return ordinal, nil
+		}
+	}
+	if n >= enginePoolMax {
+		return order[0], nil
+	}
+
+	// all the engines are busy, grow the pool
+	fmt.Printf("Dagger engines are all busy with %d sessions or more, scaling the pool to %d engines\n", engineSessionsMax, n+1)
+	replicas := int32(n + 1)
+	sts.Spec.Replicas = &replicas
+	if _, err = clientset.AppsV1().StatefulSets(kubeNamespace).Update(ctx, sts, metav1.UpdateOptions{}); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// startEngineSession records a session of this invocation on the engine pod,
+// renewing it until released.
+func startEngineSession(ctx context.Context, clientset kubernetes.Interface, pod string) (*engineSession, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	holder := pipelineID()
+	duration := int32(engineSessionDuration.Seconds())
+	now := metav1.NewMicroTime(time.Now())
+
+	lease, err := clientset.CoordinationV1().Leases(kubeNamespace).Create(ctx, &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: engineSessionName + "-" + hex.EncodeToString(suffix),
+			Labels: map[string]string{
+				"app.kubernetes.io/name":       engineSessionName,
+				"app.kubernetes.io/managed-by": fieldManager,
+				engineSessionLabel:             pod,
+			},
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &duration,
+			AcquireTime:          &now,
+			RenewTime:            &now,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &engineSession{pod: pod, stop: make(chan struct{}), stopForward: make(chan struct{})}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		leases := clientset.CoordinationV1().Leases(kubeNamespace)
+		ticker := time.NewTicker(engineSessionDuration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+				if err := leases.Delete(ctx, lease.Name, metav1.DeleteOptions{}); err != nil && !kerr.IsNotFound(err) {
+					fmt.Println("Warning: unable to end the Dagger engine session:", err)
+				}
+				return
+			case <-ticker.C:
+			}
+			now := metav1.NewMicroTime(time.Now())
+			lease.Spec.RenewTime = &now
+			renewed, err := leases.Update(context.Background(), lease, metav1.UpdateOptions{})
+			if err != nil {
+				fmt.Println("Warning: unable to renew the Dagger engine session:", err)
+				continue
+			}
+			lease = renewed
+		}
+	}()
+	return s, nil
+}
+
+// Release ends the session, and stops forwarding to the engine.  Releasing a
+// nil session, or a session again, has no effect.
+func (s *engineSession) Release() {
+	if s == nil {
+		return
+	}
+	s.once.Do(func() {
+		close(s.stopForward)
+		close(s.stop)
+	})
+	s.wg.Wait()
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// sessionLease returns a session Lease on the engine pod, last renewed at
+// renewed.
+func sessionLease(name, pod string, renewed time.Time) *coordinationv1.Lease {
+	holder := "other"
+	duration := int32(engineSessionDuration.Seconds())
+	renewTime := metav1.NewMicroTime(renewed)
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: kubeNamespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/name": engineSessionName,
+				engineSessionLabel:       pod,
+			},
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &duration,
+			RenewTime:            &renewTime,
+		},
+	}
+}
+
+// poolStatefulSet returns the StatefulSet of a pool of n engines
+func poolStatefulSet(n int32) *appsv1.StatefulSet {
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: engineName, Namespace: kubeNamespace},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &n},
+	}
+}
+
+func TestActiveSessionsCollectsExpired(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset(
+		sessionLease("active", enginePodName(0), time.Now()),
+		sessionLease("expired", enginePodName(0), time.Now().Add(-2*engineSessionDuration)),
+	)
+
+	sessions, err := activeSessions(ctx, clientset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := sessions[enginePodName(0)]; n != 1 {
+		t.Errorf("active sessions = %d, want 1", n)
+	}
+	list, err := clientset.CoordinationV1().Leases(kubeNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 || list.Items[0].Name != "active" {
+		t.Errorf("session leases = %v, want only the active one left", list.Items)
+	}
+}
+
+func TestSelectEngine(t *testing.T) {
+	defer func(max, sessionsMax int, selection string) {
+		enginePoolMax, engineSessionsMax, engineSelection = max, sessionsMax, selection
+	}(enginePoolMax, engineSessionsMax, engineSelection)
+	engineSessionsMax = 2
+
+	const key = "my-function"
+	hashed, next := hashRanking(key, 3)[0], hashRanking(key, 3)[1]
+	tests := []struct {
+		name      string
+		selection string
+		max       int
+		replicas  int32
+		sessions  map[int]int // active sessions by engine ordinal
+		want      int
+		scaled    int32
+	}{
+		{name: "hash, idle", selection: selectByHash, max: 3, replicas: 3, want: hashed, scaled: 3},
+		{name: "hash, below the threshold", selection: selectByHash, max: 3, replicas: 3, sessions: map[int]int{hashed: 1}, want: hashed, scaled: 3},
+		{name: "hash, busy", selection: selectByHash, max: 4, replicas: 3, sessions: map[int]int{hashed: 2}, want: next, scaled: 3},
+		{name: "hash, busy next", selection: selectByHash, max: 4, replicas: 3, sessions: map[int]int{hashed: 2, next: 3}, want: 3 - hashed - next, scaled: 3},
+		{name: "hash, all busy", selection: selectByHash, max: 4, replicas: 3, sessions: map[int]int{0: 2, 1: 2, 2: 2}, want: 3, scaled: 4},
+		{name: "hash, all busy at max", selection: selectByHash, max: 3, replicas: 3, sessions: map[int]int{0: 2, 1: 2, 2: 2}, want: hashed, scaled: 3},
+		{name: "load", selection: selectByLoad, max: 3, replicas: 3, sessions: map[int]int{0: 1, 1: 1}, want: 2, scaled: 3},
+		{name: "load, busy", selection: selectByLoad, max: 3, replicas: 1, sessions: map[int]int{0: 2}, want: 1, scaled: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enginePoolMax, engineSelection = tt.max, tt.selection
+			objects := []runtime.Object{poolStatefulSet(tt.replicas)}
+			for ordinal, n := range tt.sessions {
+				for i := 0; i < n; i++ {
+					name := fmt.Sprintf("session-%d-%d", ordinal, i)
+					objects = append(objects, sessionLease(name, enginePodName(ordinal), time.Now()))
+				}
+			}
+			clientset := fake.NewSimpleClientset(objects...)
+
+			ctx := context.Background()
+			got, err := selectEngine(ctx, clientset, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ordinal = %d, want %d", got, tt.want)
+			}
+			sts, err := clientset.AppsV1().StatefulSets(kubeNamespace).Get(ctx, engineName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *sts.Spec.Replicas != tt.scaled {
+				t.Errorf("replicas = %d, want %d", *sts.Spec.Replicas, tt.scaled)
+			}
+		})
+	}
+}
+
+func TestHashRanking(t *testing.T) {
+	for _, key := range []string{"a", "my-function", "other"} {
+		ranking := hashRanking(key, 4)
+		seen := map[int]bool{}
+		for _, ordinal := range ranking {
+			seen[ordinal] = true
+		}
+		if len(ranking) != 4 || len(seen) != 4 {
+			t.Errorf("hashRanking(%q, 4) = %v, want each ordinal once", key, ranking)
+		}
+		// growing the pool only moves keys to the new engine
+		if grown := hashOrdinal(key, 5); grown != 4 && grown != ranking[0] {
+			t.Errorf("hashOrdinal(%q) moved from %d to %d growing the pool", key, ranking[0], grown)
+		}
+	}
+}
+
+func TestEngineSessionReleaseTwice(t *testing.T) {
+	session, err := startEngineSession(context.Background(), fake.NewSimpleClientset(), enginePodName(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Release()
+	session.Release()
+}
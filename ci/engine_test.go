@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRemoveLegacyEngine(t *testing.T) {
+	ctx := context.Background()
+	legacy := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: engineName, Namespace: kubeNamespace}}
+	owned := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:            engineName,
+		Namespace:       kubeNamespace,
+		OwnerReferences: []metav1.OwnerReference{{Kind: "StatefulSet", Name: "other"}},
+	}}
+
+	tests := []struct {
+		name    string
+		pods    []*v1.Pod
+		removed bool
+	}{
+		{name: "no pod"},
+		{name: "legacy pod", pods: []*v1.Pod{legacy}, removed: true},
+		{name: "owned pod", pods: []*v1.Pod{owned}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			for _, pod := range tt.pods {
+				if _, err := clientset.CoreV1().Pods(kubeNamespace).Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := removeLegacyEngine(ctx, clientset); err != nil {
+				t.Fatal(err)
+			}
+			_, err := clientset.CoreV1().Pods(kubeNamespace).Get(ctx, engineName, metav1.GetOptions{})
+			if gone := kerr.IsNotFound(err); len(tt.pods) > 0 && gone != tt.removed {
+				t.Errorf("pod removed = %v, want %v", gone, tt.removed)
+			}
+		})
+	}
+}
+
+func TestReconcileEngineKeepsConfig(t *testing.T) {
+	ctx := context.Background()
+	defer func(size string, replace bool, cpu string) {
+		engineStorageSize, replaceEngine, engineCPU = size, replace, cpu
+	}(engineStorageSize, replaceEngine, engineCPU)
+	engineStorageSize = "10Gi"
+
+	deployed := EngineConfig{NodeSelector: map[string]string{"pool": "builds"}}
+	sts, err := engineStatefulSet(engineImageRepository+":v0.3.12", deployed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sts.Namespace = kubeNamespace
+	clientset := fake.NewSimpleClientset(sts)
+
+	// without config flags, replacing the engine keeps its config
+	replaceEngine, engineCPU = true, ""
+	if err = reconcileEngine(ctx, clientset, engineImageRepository+":v0.3.13", EngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := clientset.AppsV1().StatefulSets(kubeNamespace).Get(ctx, engineName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image := got.Spec.Template.Spec.Containers[0].Image; image != engineImageRepository+":v0.3.13" {
+		t.Errorf("image = %v, want it replaced", image)
+	}
+	if selector := got.Spec.Template.Spec.NodeSelector; selector["pool"] != "builds" {
+		t.Errorf("node selector = %v, want the deployed one kept", selector)
+	}
+
+	// config given explicitly is applied
+	engineCPU = "2"
+	cfg, err := loadEngineConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = reconcileEngine(ctx, clientset, engineImageRepository+":v0.3.13", cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = clientset.AppsV1().StatefulSets(kubeNamespace).Get(ctx, engineName, metav1.GetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if selector := got.Spec.Template.Spec.NodeSelector; len(selector) != 0 {
+		t.Errorf("node selector = %v, want the given config applied", selector)
+	}
+}
+
+func TestReconcileEngineUnpublishesPort(t *testing.T) {
+	ctx := context.Background()
+	defer func(size string, replace bool) {
+		engineStorageSize, replaceEngine = size, replace
+	}(engineStorageSize, replaceEngine)
+	engineStorageSize = "10Gi"
+
+	image := engineImageRepository + ":v0.3.13"
+	sts, err := engineStatefulSet(image, EngineConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sts.Namespace = kubeNamespace
+	sts.Spec.Template.Spec.Containers[0].Args = []string{"--addr", engineSocket, "--addr", "tcp://0.0.0.0:1234"}
+	svc := engineService()
+	svc.Namespace = kubeNamespace
+	svc.Spec.Ports = []v1.ServicePort{{Name: "buildkit", Port: enginePort}}
+	clientset := fake.NewSimpleClientset(sts, svc)
+
+	replaceEngine = false
+	if err = reconcileEngine(ctx, clientset, image, EngineConfig{}); err == nil {
+		t.Fatal("expected an engine listening on all interfaces to be refused")
+	}
+
+	replaceEngine = true
+	if err = reconcileEngine(ctx, clientset, image, EngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := clientset.AppsV1().StatefulSets(kubeNamespace).Get(ctx, engineName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, arg := range got.Spec.Template.Spec.Containers[0].Args {
+		if strings.HasPrefix(arg, "tcp://") && !strings.HasPrefix(arg, "tcp://127.0.0.1:") {
+			t.Errorf("engine listens on %v, want the loopback interface only", arg)
+		}
+	}
+	if svc, err = clientset.CoreV1().Services(kubeNamespace).Get(ctx, engineName, metav1.GetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.Spec.Ports) > 0 {
+		t.Errorf("engine Service publishes %v, want no ports", svc.Spec.Ports)
+	}
+}
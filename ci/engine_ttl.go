@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// engineTTLAnnotation is the annotation of the engine holding the time
+	// after which it is removed once idle.
+	engineTTLAnnotation = "dagger-knative/ttl"
+
+	// engineLastUsedAnnotation is the annotation of the engine holding the
+	// time it was last acquired.
+	engineLastUsedAnnotation = "dagger-knative/last-used"
+
+	// engineRemoveTimeout is the time given to remove the engine on interrupt
+	engineRemoveTimeout = 30 * time.Second
+)
+
+// engineTTL is the idle time after which the engine is removed, never when 0
+var engineTTL time.Duration
+
+// engineTTLAnnotations returns the annotations of a new engine
+func engineTTLAnnotations() map[string]string {
+	annotations := map[string]string{
+		engineLastUsedAnnotation: time.Now().UTC().Format(time.RFC3339),
+		engineHolderAnnotation:   pipelineID(),
+	}
+	if engineTTL > 0 {
+		annotations[engineTTLAnnotation] = engineTTL.String()
+	}
+	return annotations
+}
+
+// touchEngine marks the engine as used now by this pipeline, updating its
+// TTL if given.
+func touchEngine(ctx context.Context, clientset kubernetes.Interface) error {
+	annotations := map[string]interface{}{}
+	for k, v := range engineTTLAnnotations() {
+		annotations[k] = v
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = clientset.AppsV1().StatefulSets(kubeNamespace).Patch(ctx, engineName, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// engineIdle returns how long the engine has been idle, and its TTL if any
+func engineIdle(annotations map[string]string) (idle, ttl time.Duration) {
+	if v, ok := annotations[engineTTLAnnotation]; ok {
+		ttl, _ = time.ParseDuration(v)
+	}
+	if v, ok := annotations[engineLastUsedAnnotation]; ok {
+		if lastUsed, err := time.Parse(time.RFC3339, v); err == nil {
+			idle = time.Since(lastUsed)
+		}
+	}
+	return
+}
+
+// expireEngine removes the engine if it has been idle past its TTL and has no
+// active sessions, keeping its volumes.
+func expireEngine(ctx context.Context, clientset kubernetes.Interface) error {
+	sts, err := clientset.AppsV1().StatefulSets(kubeNamespace).Get(ctx, engineName, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	idle, ttl := engineIdle(sts.Annotations)
+	if ttl <= 0 || idle < ttl {
+		return nil
+	}
+
+	// a build may run for longer than the TTL
+	sessions, err := activeSessions(ctx, clientset)
+	if err != nil {
+		return err
+	}
+	for pod, n := range sessions {
+		if n > 0 {
+			fmt.Printf("Dagger engine idle past its TTL of %v, but %v has active sessions, keeping it\n", ttl, pod)
+			return nil
+		}
+	}
+	fmt.Printf("Dagger engine idle for %v, past its TTL of %v, removing it\n", idle.Round(time.Second), ttl)
+	return removeEngine(ctx, clientset, false)
+}
+
+// removeEngine deletes the StatefulSet and Service of the engine, and its
+// volumes if requested, waiting for the StatefulSet to be gone.  The bare pod
+// of earlier versions is deleted too.  Resources which do not exist are
+// ignored.
+func removeEngine(ctx context.Context, clientset kubernetes.Interface, volumes bool) error {
+	if err := removeLegacyEngine(ctx, clientset); err != nil {
+		return err
+	}
+	// the StatefulSet is only gone once its pod is, such that a new engine
+	// does not race with it for its volume
+	propagation := metav1.DeletePropagationForeground
+	err := clientset.AppsV1().StatefulSets(kubeNamespace).Delete(ctx, engineName, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	err = clientset.CoreV1().Services(kubeNamespace).Delete(ctx, engineName, metav1.DeleteOptions{})
+	if err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	err = wait.PollImmediateUntilWithContext(ctx, time.Second, func(ctx context.Context) (bool, error) {
+		_, err := clientset.AppsV1().StatefulSets(kubeNamespace).Get(ctx, engineName, metav1.GetOptions{})
+		if kerr.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for the Dagger engine to be removed: %w", err)
+	}
+
+	if volumes {
+		err = clientset.CoreV1().PersistentVolumeClaims(kubeNamespace).DeleteCollection(ctx, metav1.DeleteOptions{}, metav1.ListOptions{
+			LabelSelector: metav1.FormatLabelSelector(&metav1.LabelSelector{MatchLabels: engineLabels()}),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeEngineOnInterrupt ends the session and removes the engine, keeping
+// its volume, when the process is interrupted, and then exits.  Used when this
+// invocation created the engine, such that an interrupted build leaves
+// nothing running.  The engine is removed holding the engine Lease, and kept
+// should other invocations have active sessions on it.
+func removeEngineOnInterrupt(clientset kubernetes.Interface, session *engineSession) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		session.Release()
+		ctx, cancel := context.WithTimeout(context.Background(), engineRemoveTimeout)
+		defer cancel()
+		err := withEngineLease(ctx, clientset, func(ctx context.Context) error {
+			sessions, err := activeSessions(ctx, clientset)
+			if err != nil {
+				return err
+			}
+			for pod, n := range sessions {
+				if n > 0 {
+					fmt.Printf("\nInterrupted, keeping the Dagger engine created by this invocation as %v has active sessions\n", pod)
+					return nil
+				}
+			}
+			fmt.Println("\nInterrupted, removing the Dagger engine created by this invocation")
+			return removeEngine(ctx, clientset, false)
+		})
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+		os.Exit(130)
+	}()
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// ttlAnnotations returns the annotations of an engine with the given TTL,
+// last used at lastUsed.
+func ttlAnnotations(ttl string, lastUsed time.Time) map[string]string {
+	return map[string]string{
+		engineTTLAnnotation:      ttl,
+		engineLastUsedAnnotation: lastUsed.UTC().Format(time.RFC3339),
+	}
+}
+
+func TestEngineIdle(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		minIdle     time.Duration
+		maxIdle     time.Duration
+		ttl         time.Duration
+	}{
+		{name: "no annotations"},
+		{name: "no TTL", annotations: map[string]string{engineLastUsedAnnotation: time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)}, minIdle: time.Hour, maxIdle: time.Hour + time.Minute},
+		{name: "invalid TTL", annotations: ttlAnnotations("a day", time.Now()), maxIdle: time.Minute},
+		{name: "invalid last use", annotations: map[string]string{engineTTLAnnotation: "1h", engineLastUsedAnnotation: "yesterday"}, ttl: time.Hour},
+		{name: "idle", annotations: ttlAnnotations("1h", time.Now().Add(-2*time.Hour)), minIdle: 2 * time.Hour, maxIdle: 2*time.Hour + time.Minute, ttl: time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idle, ttl := engineIdle(tt.annotations)
+			if idle < tt.minIdle || idle > tt.maxIdle {
+				t.Errorf("idle = %v, want between %v and %v", idle, tt.minIdle, tt.maxIdle)
+			}
+			if ttl != tt.ttl {
+				t.Errorf("ttl = %v, want %v", ttl, tt.ttl)
+			}
+		})
+	}
+}
+
+// ttlStatefulSet returns the StatefulSet of the engine with the given
+// annotations, and its Service.
+func ttlStatefulSet(annotations map[string]string) []runtime.Object {
+	return []runtime.Object{
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: engineName, Namespace: kubeNamespace, Annotations: annotations}},
+		&v1.Service{ObjectMeta: metav1.ObjectMeta{Name: engineName, Namespace: kubeNamespace}},
+	}
+}
+
+func TestExpireEngine(t *testing.T) {
+	expired := ttlAnnotations("1h", time.Now().Add(-2*time.Hour))
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		removed bool
+	}{
+		{name: "no engine"},
+		{name: "no TTL", objects: ttlStatefulSet(map[string]string{engineLastUsedAnnotation: time.Now().Add(-48 * time.Hour).UTC().Format(time.RFC3339)})},
+		{name: "invalid TTL", objects: ttlStatefulSet(ttlAnnotations("a day", time.Now().Add(-48*time.Hour)))},
+		{name: "not yet idle", objects: ttlStatefulSet(ttlAnnotations("1h", time.Now().Add(-time.Minute)))},
+		{name: "expired", objects: ttlStatefulSet(expired), removed: true},
+		{name: "expired with an active session", objects: append(ttlStatefulSet(expired), sessionLease("active", enginePodName(0), time.Now()))},
+		{name: "expired with an expired session", objects: append(ttlStatefulSet(expired), sessionLease("expired", enginePodName(0), time.Now().Add(-2*engineSessionDuration))), removed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			clientset := fake.NewSimpleClientset(tt.objects...)
+			if err := expireEngine(ctx, clientset); err != nil {
+				t.Fatal(err)
+			}
+			if len(tt.objects) == 0 {
+				return
+			}
+
+			_, err := clientset.AppsV1().StatefulSets(kubeNamespace).Get(ctx, engineName, metav1.GetOptions{})
+			if removed := kerr.IsNotFound(err); removed != tt.removed {
+				t.Errorf("engine removed = %v, want %v (%v)", removed, tt.removed, err)
+			}
+			_, err = clientset.CoreV1().Services(kubeNamespace).Get(ctx, engineName, metav1.GetOptions{})
+			if removed := kerr.IsNotFound(err); removed != tt.removed {
+				t.Errorf("engine service removed = %v, want %v (%v)", removed, tt.removed, err)
+			}
+		})
+	}
+}
+
+// TestExpireEngineWaitsForLease ensures an expired engine is not removed
+// while another invocation holds the engine Lease, as it may be using it.
+func TestExpireEngineWaitsForLease(t *testing.T) {
+	clientset := fake.NewSimpleClientset(append(ttlStatefulSet(ttlAnnotations("1h", time.Now().Add(-2*time.Hour))),
+		heldLease("other", time.Now()))...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err := withEngineLease(ctx, clientset, func(ctx context.Context) error {
+		return expireEngine(ctx, clientset)
+	})
+	if err == nil {
+		t.Fatal("expected the held lease to be waited for until the context is done")
+	}
+
+	_, err = clientset.AppsV1().StatefulSets(kubeNamespace).Get(context.Background(), engineName, metav1.GetOptions{})
+	if err != nil {
+		t.Errorf("engine removed while the lease is held by another invocation: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+)
+
+const (
+	// engineImageRepository is the repository of the engine image
+	engineImageRepository = "registry.dagger.io/engine"
+
+	// daggerSDKModule is the module of the Dagger Go SDK
+	daggerSDKModule = "dagger.io/dagger"
+
+	// pinnedSDKVersion is the version of the SDK pinned by go.mod, used when
+	// the version can not be read from the build info of the binary.
+	pinnedSDKVersion = "v0.4.6"
+)
+
+// sdkEngineVersions are the versions of the engine each version of the SDK
+// provisions locally (CLIVersion of the SDK's engineconn package).  The remote
+// engine must run the same version.
+var sdkEngineVersions = map[string]string{
+	"v0.4.6": "0.3.13",
+}
+
+var (
+	// engineVersion overrides the version of the remote engine derived from
+	// the SDK.
+	engineVersion string
+
+	// replaceEngine indicates to replace a remote engine of another version
+	// (or config) instead of refusing (or ignoring) the difference.
+	replaceEngine bool
+)
+
+// sdkVersion returns the version of the Dagger SDK the binary was built with
+func sdkVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path != daggerSDKModule {
+				continue
+			}
+			if dep.Replace != nil {
+				dep = dep.Replace
+			}
+			if dep.Version != "" && dep.Version != "(devel)" {
+				return dep.Version
+			}
+		}
+	}
+	return pinnedSDKVersion
+}
+
+// expectedEngineImage returns the image of the engine matching the SDK, or
+// of the version given by --engine-version.
+func expectedEngineImage() (string, error) {
+	version := engineVersion
+	if version == "" {
+		sdk := sdkVersion()
+		var ok bool
+		if version, ok = sdkEngineVersions[sdk]; !ok {
+			return "", fmt.Errorf("engine version of Dagger SDK %v is unknown, set it with --engine-version", sdk)
+		}
+	}
+	return engineImageRepository + ":v" + strings.TrimPrefix(version, "v"), nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestSDKEngineVersions fails when the Dagger SDK required by go.mod has no
+// engine version, such that bumping the SDK breaks CI rather than remote
+// builds.
+func TestSDKEngineVersions(t *testing.T) {
+	f, err := os.Open(filepath.Join("..", "go.mod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var sdk string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(scanner.Text()), "require "))
+		if len(fields) >= 2 && fields[0] == daggerSDKModule {
+			sdk = fields[1]
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if sdk == "" {
+		t.Fatalf("go.mod does not require %v", daggerSDKModule)
+	}
+
+	version, ok := sdkEngineVersions[sdk]
+	if !ok {
+		t.Fatalf("sdkEngineVersions has no engine version for %v %v required by go.mod", daggerSDKModule, sdk)
+	}
+	if sdk != pinnedSDKVersion {
+		t.Errorf("pinnedSDKVersion is %v, while go.mod requires %v", pinnedSDKVersion, sdk)
+	}
+
+	// the engine the SDK provisions locally, when its source is available
+	out, err := exec.Command("go", "list", "-m", "-f", "{{.Dir}}", daggerSDKModule).Output()
+	if err != nil || strings.TrimSpace(string(out)) == "" {
+		t.Skipf("source of %v is not available to cross-check the engine version", daggerSDKModule)
+	}
+	src, err := os.ReadFile(filepath.Join(strings.TrimSpace(string(out)), "internal", "engineconn", "version.gen.go"))
+	if err != nil {
+		t.Skipf("engine version of %v not found: %v", daggerSDKModule, err)
+	}
+	match := regexp.MustCompile(`CLIVersion = "([^"]+)"`).FindSubmatch(src)
+	if match == nil {
+		t.Skipf("engine version of %v not found", daggerSDKModule)
+	}
+	if string(match[1]) != version {
+		t.Errorf("engine version of %v %v is %v, sdkEngineVersions has %v", daggerSDKModule, sdk, match[1], version)
+	}
+}
@@ -381,6 +381,8 @@ func (f Function) Initialized() bool {
 
 // ImageWithDigest returns the full reference to the image including SHA256 Digest.
 // If Digest is empty, image:tag is returned.
+// The tag (and any digest already present) of the image is replaced by the
+// digest.  If the image is not a valid reference it is returned unmodified.
 // TODO: Populate this only on a successful deploy, as this results on a dirty
 // git tree on every build.
 func (f Function) ImageWithDigest() string {
@@ -389,14 +391,15 @@ func (f Function) ImageWithDigest() string {
 		return f.Image
 	}
 
-	lastSlashIdx := strings.LastIndexAny(f.Image, "/")
-	imageAsBytes := []byte(f.Image)
-
-	part1 := string(imageAsBytes[:lastSlashIdx+1])
-	part2 := string(imageAsBytes[lastSlashIdx+1:])
+	ref, err := ParseImageReference(f.Image)
+	if err != nil {
+		return f.Image
+	}
 
 	// Remove tag from the image name and append SHA256 hash instead
-	return part1 + strings.Split(part2, ":")[0] + "@" + f.ImageDigest
+	ref.Tag = ""
+	ref.Digest = f.ImageDigest
+	return ref.String()
 }
 
 // LabelsMap combines default labels with the labels slice provided.
@@ -488,8 +491,10 @@ func assertEmptyRoot(path string) (err error) {
 // form:    [registry]/[parent]/[user]/[function]:latest
 // example: quay.io/project/alice/my.function.name:latest
 //
-// Registry values which only contain a single token are presumed to
-// indicate the namespace at the default registry.
+// Registry values whose first token is not a registry host (contains no '.'
+// or ':' and is not 'localhost') are presumed to indicate the namespace at
+// the default registry, ex. 'alice' or 'alice/team'.
+// Registries with ports are supported, ex. 'localhost:5000/alice'.
 func (f Function) ImageName() (image string, err error) {
 	if f.Registry == "" {
 		return "", ErrRegistryRequired
@@ -500,15 +505,12 @@ func (f Function) ImageName() (image string, err error) {
 
 	f.Registry = strings.Trim(f.Registry, "/") // too defensive?
 
-	registryTokens := strings.Split(f.Registry, "/")
-	if len(registryTokens) == 1 { // only namespace provided: ex. 'alice'
-		image = DefaultRegistry + "/" + f.Registry + "/" + f.Name
-	} else if len(registryTokens) == 2 || len(registryTokens) == 3 {
-		// registry/namespace ('quay.io/alice') or
-		// registry/parent-namespace/namespace ('quay.io/project/alice') provided
-		image = f.Registry + "/" + f.Name
-	} else if len(registryTokens) > 3 { // the name of the image is also provided `quay.io/alice/my.function.name`
-		return "", fmt.Errorf("registry should be either 'namespace', 'registry/namespace' or 'registry/parent/namespace', the name of the image will be derived from the function name.")
+	ref, err := ParseImageReference(f.Registry + "/" + f.Name)
+	if err != nil {
+		return "", fmt.Errorf("registry should be in the form '[registry/]namespace', the name of the image will be derived from the function name: %w", err)
+	}
+	if ref.Registry == "" {
+		ref.Registry = DefaultRegistry
 	}
 
 	// Explicitly append :latest tag.  We expect source control to drive
@@ -517,7 +519,8 @@ func (f Function) ImageName() (image string, err error) {
 	// to branch name when we add source-driven canary/ bluegreen deployments.
 
 	// For pinning to an exact container image, see ImageWithDigest
-	return image + ":latest", nil
+	ref.Tag = "latest"
+	return ref.String(), nil
 }
 
 // contentiousFiles are files which, if extant, preclude the creation of a
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/moby/patternmatcher"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// RunDataDir holds transient runtime metadata of a function, such as the
+	// fingerprints of its last successful builds.  It is not source and should
+	// not be committed.
+	RunDataDir = ".func"
+
+	// builtFilePrefix is the prefix of the files within RunDataDir storing the
+	// fingerprint of the last successful build of each stage.
+	builtFilePrefix = "built-"
+
+	// publishedFile is the file within RunDataDir recording the digest of the
+	// image last published, such that the function file is left untouched.
+	publishedFile = "published"
+)
+
+// Fingerprint returns a hash of the function's source plus the fields of its
+// spec which are relevant to a build.  Ignored paths of the source are
+// excluded, as is the function file of which only the build-relevant fields
+// are hashed.  Extra inputs of the build (flags etc.) are included when
+// provided, such that changing them also changes the fingerprint.
+func (f Function) Fingerprint(extra ...string) (string, error) {
+	h := sha256.New()
+
+	// build-relevant fields of the spec
+	spec, err := yaml.Marshal(struct {
+		Runtime string    `yaml:"runtime"`
+		Image   string    `yaml:"image"`
+		Build   BuildSpec `yaml:"build"`
+	}{f.Runtime, f.Image, f.Build})
+	if err != nil {
+		return "", err
+	}
+	h.Write(spec)
+
+	for _, e := range extra {
+		fmt.Fprintf(h, "extra:%s\x00", e)
+	}
+
+	patterns, err := ignorePatterns(f.Root)
+	if err != nil {
+		return "", err
+	}
+	ignored, err := patternmatcher.New(append(patterns, FunctionFile))
+	if err != nil {
+		return "", err
+	}
+
+	// source, walked in lexical order
+	err = filepath.WalkDir(f.Root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(f.Root, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		skip, err := ignored.MatchesOrParentMatches(rel)
+		if err != nil {
+			return err
+		}
+		if skip {
+			// re-included paths may be nested in excluded directories
+			if d.IsDir() && !ignored.Exclusions() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%v\x00", filepath.ToSlash(rel), info.Mode())
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(h, file)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Built returns whether the last successful build of the given stage
+// (build, package etc.) has the given fingerprint, meaning it is up to date.
+// Any errors reading the stamp are considered not built.
+func (f Function) Built(stage, fingerprint string) bool {
+	bb, err := os.ReadFile(f.builtFile(stage))
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(bb)) == fingerprint
+}
+
+// Stamp records the fingerprint of a successful build of the given stage.
+func (f Function) Stamp(stage, fingerprint string) error {
+	if f.Root == "" {
+		return errors.New("function root path is required")
+	}
+	if err := os.MkdirAll(filepath.Join(f.Root, RunDataDir), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(f.builtFile(stage), []byte(fingerprint+"\n"), 0o644)
+}
+
+func (f Function) builtFile(stage string) string {
+	return filepath.Join(f.Root, RunDataDir, builtFilePrefix+stage)
+}
+
+// PublishedDigest returns the digest of the image last published, recorded
+// in RunDataDir, or the digest of the function file if none was recorded.
+func (f Function) PublishedDigest() string {
+	bb, err := os.ReadFile(filepath.Join(f.Root, RunDataDir, publishedFile))
+	if err != nil {
+		return f.ImageDigest
+	}
+	if digest := strings.TrimSpace(string(bb)); digest != "" {
+		return digest
+	}
+	return f.ImageDigest
+}
+
+// RecordPublished records the digest of a published image of the function.
+func (f Function) RecordPublished(digest string) error {
+	if f.Root == "" {
+		return errors.New("function root path is required")
+	}
+	if err := os.MkdirAll(filepath.Join(f.Root, RunDataDir), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(f.Root, RunDataDir, publishedFile), []byte(digest+"\n"), 0o644)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// gitCheckoutScript writes the tree of a revision of a local repository to a
+// directory, without the repository itself.  The repository is mirrored such
+// that its branches, tags and commits all resolve.
+// Arguments: repository, mirror path, output directory, revision.
+const gitCheckoutScript = `set -e
+git clone --quiet --mirror "$1" "$2"
+git --git-dir "$2" archive --output "$2/tree.tar" "$4"
+mkdir -p "$3"
+tar -x -f "$2/tree.tar" -C "$3"
+`
+
+// regCommit matches a full git commit hash
+var regCommit = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// fromGit indicates the function source is fetched from the git repository
+// defined in its build spec rather than taken from the local directory.
+var fromGit bool
+
+// functionSource returns the directory holding the source of the function.
+// When fromGit is set the source is fetched from the function's git
+// repository as is, otherwise the local directory is used excluding its
+// ignored paths.
+func functionSource(c *dagger.Client, fn Function) (*dagger.Directory, error) {
+	if !fromGit {
+		opts, err := sourceDirectoryOpts(fn.Root)
+		if err != nil {
+			return nil, err
+		}
+		return c.Host().Directory(fn.Root, opts), nil
+	}
+	return gitSource(c, fn.Build.Git)
+}
+
+// gitSource returns the tree of the given git repository at its revision,
+// scoped to its context directory.  Revisions may be a branch, a tag or a
+// full commit hash, and default to HEAD.
+// Local repositories (file:// URLs or paths), including bare ones, are
+// cloned from the host as they are not reachable by the engine.
+func gitSource(c *dagger.Client, git Git) (*dagger.Directory, error) {
+	if git.URL == "" {
+		return nil, errors.New("build.git.url is required to build from git")
+	}
+
+	revision := git.Revision
+	if revision == "" {
+		revision = "HEAD"
+	}
+	fmt.Printf("Fetching source from %v at %v\n", git.URL, revision)
+
+	var src *dagger.Directory
+	if path, ok := localGitPath(git.URL); ok {
+		src = c.Container().From("alpine/git").
+			WithMountedDirectory("/repo", c.Host().Directory(path)).
+			WithEntrypoint([]string{"sh", "-c", gitCheckoutScript, "sh"}).
+			WithExec([]string{"/repo", "/tmp/src.git", "/src", revision}).
+			Directory("/src")
+	} else {
+		if errs := validateGit(git); len(errs) > 0 {
+			return nil, errors.New(strings.Join(errs, "\n"))
+		}
+		repo := c.Git(git.URL)
+		if regCommit.MatchString(revision) {
+			src = repo.Commit(revision).Tree()
+		} else {
+			src = repo.Branch(revision).Tree()
+		}
+	}
+
+	if git.ContextDir != "" {
+		src = src.Directory(git.ContextDir)
+	}
+	return src, nil
+}
+
+// localGitPath returns the path on the host of a repository given by a
+// file:// URL or a plain path to an existing directory.
+func localGitPath(url string) (string, bool) {
+	if strings.HasPrefix(url, "file://") {
+		return strings.TrimPrefix(url, "file://"), true
+	}
+	if fd, err := os.Stat(url); err == nil && fd.IsDir() {
+		return url, true
+	}
+	return "", false
+}
+
+// pinnedGitRevision returns whether the function's git revision is a full
+// commit hash, such that its source can not change between builds.
+func pinnedGitRevision(git Git) bool {
+	return regCommit.MatchString(git.Revision)
+}
+
+// resolveGitRevision returns the commit the function's git revision refers
+// to in its repository.  Branches and tags move, so the source of a published
+// image is pinned to the commit it resolved to when the build started.
+func resolveGitRevision(git Git) (string, error) {
+	if pinnedGitRevision(git) {
+		return git.Revision, nil
+	}
+	revision := git.Revision
+	if revision == "" {
+		revision = "HEAD"
+	}
+
+	cmd := exec.Command("git", "ls-remote", "--", git.URL, revision)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve revision '%v' of %v: %w", revision, redactURL(git.URL), err)
+	}
+	refs := map[string]string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if commit, ref, ok := strings.Cut(line, "\t"); ok {
+			refs[ref] = commit
+		}
+	}
+	// annotated tags resolve to the commit they were peeled to
+	for _, ref := range []string{revision, "refs/heads/" + revision, "refs/tags/" + revision + "^{}", "refs/tags/" + revision} {
+		if commit, ok := refs[ref]; ok && regCommit.MatchString(commit) {
+			return commit, nil
+		}
+	}
+	return "", fmt.Errorf("revision '%v' is not a branch, tag or full commit hash of %v", revision, redactURL(git.URL))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"dagger.io/dagger"
+)
+
+// gitRepo is a bare repository of the tests, with a commit on main (tagged
+// v1.0.0), a later commit on main, and a commit on a feature branch.
+type gitRepo struct {
+	path    string
+	tagged  string // commit tagged v1.0.0
+	main    string // head of main
+	feature string // head of feature
+}
+
+// newBareRepo creates the bare repository of the tests in a temp dir.  Each
+// commit writes its name to version.txt and app/version.txt.
+func newBareRepo(t *testing.T) gitRepo {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+	dir := t.TempDir()
+	repo := gitRepo{path: filepath.Join(dir, "repo.git")}
+	work := filepath.Join(dir, "work")
+
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	commit := func(version string) string {
+		t.Helper()
+		for _, f := range []string{"version.txt", "app/version.txt"} {
+			path := filepath.Join(work, f)
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(version), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		git(work, "add", "-A")
+		git(work, "commit", "--quiet", "-m", version)
+		return git(work, "rev-parse", "HEAD")
+	}
+
+	git(dir, "init", "--quiet", "--bare", "--initial-branch=main", repo.path)
+	git(dir, "init", "--quiet", "--initial-branch=main", work)
+	repo.tagged = commit("tagged")
+	git(work, "tag", "v1.0.0")
+	repo.main = commit("main")
+	git(work, "checkout", "--quiet", "-b", "feature", repo.tagged)
+	repo.feature = commit("feature")
+	git(work, "push", "--quiet", "--tags", repo.path, "main", "feature")
+	return repo
+}
+
+// gitRevisionTests are the revisions of the test repository, with the
+// contents of version.txt at each.
+func gitRevisionTests(repo gitRepo) []struct{ name, revision, want string } {
+	return []struct{ name, revision, want string }{
+		{"default", "", "main"},
+		{"HEAD", "HEAD", "main"},
+		{"default branch", "main", "main"},
+		{"branch", "feature", "feature"},
+		{"tag", "v1.0.0", "tagged"},
+		{"commit", repo.tagged, "tagged"},
+		{"commit of branch", repo.feature, "feature"},
+	}
+}
+
+func TestGitCheckoutScript(t *testing.T) {
+	repo := newBareRepo(t)
+	for _, tt := range gitRevisionTests(repo) {
+		t.Run(tt.name, func(t *testing.T) {
+			revision := tt.revision
+			if revision == "" {
+				revision = "HEAD"
+			}
+			dir := t.TempDir()
+			out := filepath.Join(dir, "src")
+			cmd := exec.Command("sh", "-c", gitCheckoutScript, "sh", repo.path, filepath.Join(dir, "src.git"), out, revision)
+			if bb, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("checkout: %v\n%s", err, bb)
+			}
+
+			got, err := os.ReadFile(filepath.Join(out, "version.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("version.txt = %q, want %q", got, tt.want)
+			}
+			if _, err = os.Stat(filepath.Join(out, ".git")); !os.IsNotExist(err) {
+				t.Errorf("source includes .git")
+			}
+		})
+	}
+
+	t.Run("unknown revision", func(t *testing.T) {
+		dir := t.TempDir()
+		cmd := exec.Command("sh", "-c", gitCheckoutScript, "sh", repo.path, filepath.Join(dir, "src.git"), filepath.Join(dir, "src"), "missing")
+		if err := cmd.Run(); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
+
+func TestGitSource(t *testing.T) {
+	if testing.Short() {
+		t.Skip("requires a Dagger engine")
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("requires a Dagger engine, docker is not available")
+	}
+	repo := newBareRepo(t)
+
+	ctx := context.Background()
+	c, err := dagger.Connect(ctx, dagger.WithLogOutput(os.Stderr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, url := range []string{repo.path, "file://" + repo.path} {
+		for _, tt := range gitRevisionTests(repo) {
+			t.Run(url+"@"+tt.name, func(t *testing.T) {
+				for _, contextDir := range []string{"", "app"} {
+					src, err := gitSource(c, Git{URL: url, Revision: tt.revision, ContextDir: contextDir})
+					if err != nil {
+						t.Fatal(err)
+					}
+					got, err := src.File("version.txt").Contents(ctx)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got != tt.want {
+						t.Errorf("version.txt in '%v' = %q, want %q", contextDir, got, tt.want)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestResolveGitRevision(t *testing.T) {
+	repo := newBareRepo(t)
+	commits := map[string]string{"main": repo.main, "feature": repo.feature, "tagged": repo.tagged}
+	for _, url := range []string{repo.path, "file://" + repo.path} {
+		for _, tt := range gitRevisionTests(repo) {
+			t.Run(url+"@"+tt.name, func(t *testing.T) {
+				got, err := resolveGitRevision(Git{URL: url, Revision: tt.revision})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := commits[tt.want]; got != want {
+					t.Errorf("resolveGitRevision(%q) = %v, want %v", tt.revision, got, want)
+				}
+			})
+		}
+	}
+
+	for _, revision := range []string{"missing", repo.main[:7]} {
+		if _, err := resolveGitRevision(Git{URL: repo.path, Revision: revision}); err == nil {
+			t.Errorf("resolveGitRevision(%q): expected an error", revision)
+		}
+	}
+}
+
+func TestLocalGitPath(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		url   string
+		path  string
+		local bool
+	}{
+		{"file://" + dir, dir, true},
+		{dir, dir, true},
+		{filepath.Join(dir, "missing"), "", false},
+		{"https://github.com/alice/fn.git", "", false},
+		{"git@github.com:alice/fn.git", "", false},
+	}
+	for _, tt := range tests {
+		path, local := localGitPath(tt.url)
+		if path != tt.path || local != tt.local {
+			t.Errorf("localGitPath(%q) = %q, %v, want %q, %v", tt.url, path, local, tt.path, tt.local)
+		}
+	}
+}
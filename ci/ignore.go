@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// IgnoreFile lists paths of the function which are excluded from its builds
+// in addition to those of its .gitignore.  It uses the .gitignore syntax.
+const IgnoreFile = ".funcignore"
+
+// defaultIgnored are the paths excluded from every upload of the function
+// directory: version control metadata, transient runtime data, the pipeline
+// itself and the results of previous builds and scans.
+var defaultIgnored = []string{
+	".git",
+	RunDataDir,
+	"ci",
+	DistDir,
+	"results.sarif",
+}
+
+// ignorePatterns returns the patterns excluding paths of the function at root
+// from uploads to the engine: the defaults, followed by those of the
+// .gitignore and .funcignore files at root (if present).  Patterns are
+// returned in the syntax of Dagger exclude patterns (.dockerignore).
+// Only the files at root are considered, not those of subdirectories.
+func ignorePatterns(root string) ([]string, error) {
+	patterns := append([]string{}, defaultIgnored...)
+	for _, name := range []string{".gitignore", IgnoreFile} {
+		pp, err := readIgnoreFile(filepath.Join(root, name))
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, pp...)
+	}
+	return patterns, nil
+}
+
+// readIgnoreFile reads the patterns of a file in .gitignore syntax,
+// converted to exclude patterns.  A missing file has no patterns.
+func readIgnoreFile(path string) (patterns []string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if p, ok := excludePattern(scanner.Text()); ok {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns, scanner.Err()
+}
+
+// excludePattern converts a line in .gitignore syntax to an exclude pattern.
+// Blank lines and comments result in no pattern.
+//   - '/foo' and 'foo/bar' are relative to the root  -> 'foo', 'foo/bar'
+//   - 'foo' matches at any depth                     -> '**/foo'
+//   - 'foo/' matches directories only, which exclude
+//     patterns can not express                       -> '**/foo'
+//   - '!foo' re-includes paths                       -> '!**/foo'
+func excludePattern(line string) (string, bool) {
+	p := strings.TrimRight(line, " \t\r")
+	if p == "" || strings.HasPrefix(p, "#") {
+		return "", false
+	}
+
+	negate := strings.HasPrefix(p, "!")
+	if negate {
+		p = p[1:]
+	}
+	// escaped leading '#' or '!'
+	if strings.HasPrefix(p, `\#`) || strings.HasPrefix(p, `\!`) {
+		p = p[1:]
+	}
+
+	p = strings.TrimSuffix(p, "/")
+	if strings.HasPrefix(p, "/") {
+		p = strings.TrimLeft(p, "/")
+	} else if !strings.Contains(p, "/") {
+		p = "**/" + p
+	}
+	if p == "" || p == "**/" {
+		return "", false
+	}
+
+	if negate {
+		p = "!" + p
+	}
+	return p, true
+}
+
+// sourceDirectoryOpts returns the options uploading the function directory
+// at root, excluding its ignored paths.
+func sourceDirectoryOpts(root string) (dagger.HostDirectoryOpts, error) {
+	patterns, err := ignorePatterns(root)
+	if err != nil {
+		return dagger.HostDirectoryOpts{}, err
+	}
+	return dagger.HostDirectoryOpts{Exclude: patterns}, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExcludePattern(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+		ok   bool
+	}{
+		{line: ""},
+		{line: "   \t"},
+		{line: "# comment"},
+		{line: "#*.log"},
+		{line: "/"},
+		{line: "!"},
+		{line: "*.log", want: "**/*.log", ok: true},
+		{line: "*.log  \r", want: "**/*.log", ok: true},
+		{line: "node_modules", want: "**/node_modules", ok: true},
+		{line: "/node_modules", want: "node_modules", ok: true},
+		{line: "//vendor", want: "vendor", ok: true},
+		{line: "build/", want: "**/build", ok: true},
+		{line: "/build/", want: "build", ok: true},
+		{line: "docs/build", want: "docs/build", ok: true},
+		{line: "docs/**/*.tmp", want: "docs/**/*.tmp", ok: true},
+		{line: "!keep.log", want: "!**/keep.log", ok: true},
+		{line: "!/keep.log", want: "!keep.log", ok: true},
+		{line: "!docs/keep/", want: "!docs/keep", ok: true},
+		{line: `\#notes`, want: "**/#notes", ok: true},
+		{line: `\!important`, want: "**/!important", ok: true},
+		{line: `!\!important`, want: "!**/!important", ok: true},
+	}
+	for _, tt := range tests {
+		got, ok := excludePattern(tt.line)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("excludePattern(%q) = (%q, %v), want (%q, %v)", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestIgnorePatterns(t *testing.T) {
+	write := func(root, name, contents string) {
+		t.Helper()
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("no ignore files", func(t *testing.T) {
+		got, err := ignorePatterns(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, defaultIgnored) {
+			t.Errorf("patterns = %v, want the defaults %v", got, defaultIgnored)
+		}
+	})
+
+	t.Run("gitignore then funcignore", func(t *testing.T) {
+		root := t.TempDir()
+		write(root, ".gitignore", "# build output\n*.log\n\n/bin/\n!keep.log\n")
+		write(root, IgnoreFile, "docs/\n\\#scratch\n")
+		// only the ignore files at root are read
+		write(root, "app/.gitignore", "*.go\n")
+		write(root, "app/"+IgnoreFile, "*\n")
+
+		got, err := ignorePatterns(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := append(append([]string{}, defaultIgnored...),
+			"**/*.log", "bin", "!**/keep.log",
+			"**/docs", "**/#scratch")
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("patterns = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("unreadable ignore file", func(t *testing.T) {
+		root := t.TempDir()
+		// a directory in place of the file can not be read
+		if err := os.Mkdir(filepath.Join(root, ".gitignore"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ignorePatterns(root); err == nil {
+			t.Error("expected an error reading the ignore file")
+		}
+	})
+}
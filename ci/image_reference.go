@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ImageReference is a parsed OCI image reference in the form:
+//
+//	[registry/]repository[:tag][@digest]
+//
+// The registry is only recognized when the first path component looks like a
+// host: it contains a '.' or a ':' (port), or is exactly "localhost".
+// Otherwise the whole path is the repository, and the registry is left empty
+// meaning DefaultRegistry is implied.
+// examples:
+//
+//	localhost:5000/alice/my.function.name:latest
+//	quay.io/project/team/alice/my.function.name
+//	alice/my.function.name@sha256:<hex>
+type ImageReference struct {
+	// Registry host, including an optional port. Empty when not specified.
+	Registry string
+
+	// Repository path, possibly with nested namespaces (ex. 'alice/fn').
+	Repository string
+
+	// Tag of the image. Empty when not specified.
+	Tag string
+
+	// Digest of the image in the form 'algorithm:hex'. Empty when not specified.
+	Digest string
+}
+
+// Regex used to validate the individual parts of an image reference.
+// These follow the grammar of the distribution reference implementation.
+var (
+	regImageRegistry  = regexp.MustCompile(`^(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)(?:\.(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?))*(?::[0-9]+)?$`)
+	regImagePathToken = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*$`)
+	regImageTag       = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	regImageDigest    = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,}$`)
+)
+
+// ParseImageReference parses and validates an image reference.
+func ParseImageReference(s string) (ref ImageReference, err error) {
+	if s == "" {
+		return ref, fmt.Errorf("image reference may not be empty")
+	}
+	name := s
+
+	// Digest: everything following the '@'
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, ref.Digest = name[:i], name[i+1:]
+		if !regImageDigest.MatchString(ref.Digest) {
+			return ImageReference{}, fmt.Errorf("image reference '%v' has invalid digest '%v'", s, ref.Digest)
+		}
+	}
+
+	// Tag: a ':' in the last path component (a ':' in an earlier component
+	// is the port of the registry)
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.Tag = name[:i], name[i+1:]
+		if !regImageTag.MatchString(ref.Tag) {
+			return ImageReference{}, fmt.Errorf("image reference '%v' has invalid tag '%v'", s, ref.Tag)
+		}
+	}
+
+	// Registry: the first path component, if it looks like a host
+	tokens := strings.Split(name, "/")
+	if len(tokens) > 1 && isRegistryHost(tokens[0]) {
+		ref.Registry, tokens = tokens[0], tokens[1:]
+		if !regImageRegistry.MatchString(ref.Registry) {
+			return ImageReference{}, fmt.Errorf("image reference '%v' has invalid registry '%v'", s, ref.Registry)
+		}
+	}
+
+	for _, t := range tokens {
+		if !regImagePathToken.MatchString(t) {
+			return ImageReference{}, fmt.Errorf("image reference '%v' has invalid repository path component '%v'", s, t)
+		}
+	}
+	ref.Repository = strings.Join(tokens, "/")
+
+	return ref, nil
+}
+
+// isRegistryHost returns whether the first path component of a reference
+// denotes a registry rather than a namespace.
+func isRegistryHost(token string) bool {
+	return strings.ContainsAny(token, ".:") || token == "localhost"
+}
+
+// Name returns the reference without tag or digest: [registry/]repository
+func (r ImageReference) Name() string {
+	if r.Registry == "" {
+		return r.Repository
+	}
+	return r.Registry + "/" + r.Repository
+}
+
+// String returns the reference in its canonical textual form.
+func (r ImageReference) String() string {
+	s := r.Name()
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseImageReference(t *testing.T) {
+	digest := "sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	tests := []struct {
+		name   string
+		in     string
+		want   ImageReference
+		str    string // expected String(), defaults to in
+		nameOf string // expected Name()
+	}{
+		{
+			name:   "repository only",
+			in:     "alice",
+			want:   ImageReference{Repository: "alice"},
+			nameOf: "alice",
+		},
+		{
+			name:   "namespace and tag",
+			in:     "alice/my.function.name:latest",
+			want:   ImageReference{Repository: "alice/my.function.name", Tag: "latest"},
+			nameOf: "alice/my.function.name",
+		},
+		{
+			name:   "registry with port",
+			in:     "localhost:5000/alice/fn:v1.2.3",
+			want:   ImageReference{Registry: "localhost:5000", Repository: "alice/fn", Tag: "v1.2.3"},
+			nameOf: "localhost:5000/alice/fn",
+		},
+		{
+			name:   "registry with port, no tag",
+			in:     "localhost:5000/fn",
+			want:   ImageReference{Registry: "localhost:5000", Repository: "fn"},
+			nameOf: "localhost:5000/fn",
+		},
+		{
+			name:   "localhost registry",
+			in:     "localhost/fn",
+			want:   ImageReference{Registry: "localhost", Repository: "fn"},
+			nameOf: "localhost/fn",
+		},
+		{
+			name:   "ip registry",
+			in:     "127.0.0.1:5000/alice/fn",
+			want:   ImageReference{Registry: "127.0.0.1:5000", Repository: "alice/fn"},
+			nameOf: "127.0.0.1:5000/alice/fn",
+		},
+		{
+			name:   "nested namespaces",
+			in:     "quay.io/project/team/alice/my.function.name",
+			want:   ImageReference{Registry: "quay.io", Repository: "project/team/alice/my.function.name"},
+			nameOf: "quay.io/project/team/alice/my.function.name",
+		},
+		{
+			name:   "digest",
+			in:     "alice/fn@" + digest,
+			want:   ImageReference{Repository: "alice/fn", Digest: digest},
+			nameOf: "alice/fn",
+		},
+		{
+			name:   "tag and digest",
+			in:     "docker.io/alice/fn:latest@" + digest,
+			want:   ImageReference{Registry: "docker.io", Repository: "alice/fn", Tag: "latest", Digest: digest},
+			nameOf: "docker.io/alice/fn",
+		},
+		{
+			name:   "registry with port and digest",
+			in:     "localhost:5000/alice/fn@" + digest,
+			want:   ImageReference{Registry: "localhost:5000", Repository: "alice/fn", Digest: digest},
+			nameOf: "localhost:5000/alice/fn",
+		},
+		{
+			name:   "separators in path",
+			in:     "ghcr.io/a_b/c__d/e-f--g/h.i:tag_1.0-rc",
+			want:   ImageReference{Registry: "ghcr.io", Repository: "a_b/c__d/e-f--g/h.i", Tag: "tag_1.0-rc"},
+			nameOf: "ghcr.io/a_b/c__d/e-f--g/h.i",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseImageReference(tt.in)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+			str := tt.str
+			if str == "" {
+				str = tt.in
+			}
+			if got.String() != str {
+				t.Errorf("String() = %q, want %q", got.String(), str)
+			}
+			if got.Name() != tt.nameOf {
+				t.Errorf("Name() = %q, want %q", got.Name(), tt.nameOf)
+			}
+		})
+	}
+}
+
+func TestParseImageReferenceInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"empty", ""},
+		{"uppercase repository", "alice/MyFunction"},
+		{"empty path component", "alice//fn"},
+		{"trailing slash", "alice/fn/"},
+		{"leading separator", "alice/-fn"},
+		{"empty tag", "alice/fn:"},
+		{"invalid tag", "alice/fn:-latest"},
+		{"tag too long", "alice/fn:" + strings.Repeat("a", 129)},
+		{"empty digest", "alice/fn@"},
+		{"digest without algorithm", "alice/fn@0123456789abcdef0123456789abcdef"},
+		{"short digest", "alice/fn@sha256:abc"},
+		{"non hex digest", "alice/fn@sha256:zzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzz"},
+		{"invalid registry", "-bad.io/fn"},
+		{"invalid registry port", "localhost:port/fn"},
+		{"registry only", "localhost:5000/"},
+		{"whitespace", "alice/ fn"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if ref, err := ParseImageReference(tt.in); err == nil {
+				t.Fatalf("expected an error, got %+v", ref)
+			}
+		})
+	}
+}
+
+func TestImageWithDigest(t *testing.T) {
+	digest := "sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	tests := []struct {
+		name   string
+		image  string
+		digest string
+		want   string
+	}{
+		{"no digest", "localhost:5000/alice/fn:latest", "", "localhost:5000/alice/fn:latest"},
+		{"replaces tag", "localhost:5000/alice/fn:latest", digest, "localhost:5000/alice/fn@" + digest},
+		{"replaces digest", "quay.io/alice/fn@sha256:" + "ff" + digest[9:], digest, "quay.io/alice/fn@" + digest},
+		{"invalid image", "Not An Image", digest, "Not An Image"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Function{Image: tt.image, ImageDigest: tt.digest}
+			if got := f.ImageWithDigest(); got != tt.want {
+				t.Errorf("ImageWithDigest() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImageName(t *testing.T) {
+	tests := []struct {
+		name     string
+		registry string
+		want     string
+		wantErr  bool
+	}{
+		{"namespace", "alice", "docker.io/alice/fn:latest", false},
+		{"registry and namespace", "quay.io/alice", "quay.io/alice/fn:latest", false},
+		{"registry with port", "localhost:5000", "localhost:5000/fn:latest", false},
+		{"registry with port and namespace", "localhost:5000/alice/team", "localhost:5000/alice/team/fn:latest", false},
+		{"trailing slash", "alice/", "docker.io/alice/fn:latest", false},
+		{"invalid", "Alice", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Function{Name: "fn", Registry: tt.registry}
+			got, err := f.ImageName()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ImageName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
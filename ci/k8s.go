@@ -2,10 +2,9 @@ package main
 
 import (
 	"context"
-	"errors"
+	"flag"
 	"fmt"
 	"os"
-	"path/filepath"
 	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -13,74 +12,178 @@ import (
 	v1 "k8s.io/api/core/v1"
 	kerr "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
 )
 
-func setupRemoteEngine(ctx context.Context) error {
-	kubecfgPath := filepath.Join(homedir.HomeDir(), ".kube", "config")
+// kubeContext overrides the current context of the kubeconfig
+var kubeContext string
 
-	kubeCfg := clientcmd.GetConfigFromFileOrDie(kubecfgPath)
+// kubeClientConfig returns the kubeconfig loader.  The kubeconfig is read
+// from the paths of $KUBECONFIG (merged in order) or ~/.kube/config, with the
+// context given by --kube-context.  Without a kubeconfig, the service account
+// of the pod is used when running in a cluster.
+func kubeClientConfig() clientcmd.ClientConfig {
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(),
+		&clientcmd.ConfigOverrides{CurrentContext: kubeContext},
+	)
+}
 
-	clientConfig, err := clientcmd.BuildConfigFromFlags("", kubecfgPath)
+// getKubeClientConfig returns the client config of the selected context
+func getKubeClientConfig() (*rest.Config, error) {
+	config, err := kubeClientConfig().ClientConfig()
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("loading kubeconfig: %w", err)
 	}
+	return config, nil
+}
 
-	// create the clientset
-	clientset, err := kubernetes.NewForConfig(clientConfig)
+// addKubeContextFlag registers the flag selecting the kubeconfig context
+func addKubeContextFlag(fs *flag.FlagSet) {
+	fs.StringVar(&kubeContext, "kube-context", "", "Kubeconfig context to use, defaults to the current context")
+}
+
+// addKubeFlags registers the flags of the remote engine
+func addKubeFlags(fs *flag.FlagSet) {
+	addKubeContextFlag(fs)
+	fs.StringVar(&kubeNamespace, "kube-namespace", "default", "Kube namespace to create the Dagger pod")
+	fs.StringVar(&engineStorageClass, "engine-storage-class", "", "Storage class of the Dagger engine's cache volume, defaults to the default class of the cluster")
+	fs.StringVar(&engineStorageSize, "engine-storage-size", "20Gi", "Size of the Dagger engine's cache volume")
+	fs.StringVar(&engineVersion, "engine-version", "", "Version of the Dagger engine (e.g. 0.3.13), defaults to the version of the Dagger SDK")
+	fs.BoolVar(&replaceEngine, "engine-replace", false, "Replaces a Dagger engine of another version, keeping its cache volume")
+	fs.StringVar(&engineConfigFile, "engine-config", "", "YAML file of the Dagger engine's resources, nodeSelector, tolerations, affinity, serviceAccountName and imagePullSecrets")
+	fs.StringVar(&engineCPU, "engine-cpu", "", "CPU guaranteed to the Dagger engine (e.g. 4), overrides the engine config")
+	fs.StringVar(&engineMemory, "engine-memory", "", "Memory guaranteed to the Dagger engine (e.g. 8Gi), overrides the engine config")
+	fs.StringVar(&engineNodeSelector, "engine-node-selector", "", "Comma separated key=value labels of the nodes to run the Dagger engine on, overrides the engine config")
+	fs.StringVar(&engineTolerations, "engine-tolerations", "", "Comma separated taints tolerated by the Dagger engine as key[=value][:effect], overrides the engine config")
+	fs.StringVar(&engineServiceAccount, "engine-service-account", "", "Service account of the Dagger engine, overrides the engine config")
+	fs.StringVar(&enginePullSecrets, "engine-image-pull-secrets", "", "Comma separated Secrets pulling the Dagger engine image, overrides the engine config")
+	fs.DurationVar(&engineTTL, "engine-ttl", 0, "Removes the Dagger engine once idle for this long (e.g. 2h), on the next invocation. Keeps its cache volume")
+	fs.IntVar(&enginePoolMax, "engine-pool-max", 1, "Maximum number of Dagger engines in the pool, scaled up when all its engines are busy")
+	fs.IntVar(&engineSessionsMax, "engine-sessions-max", 4, "Number of active sessions from which a Dagger engine of the pool is busy, and sessions go to another engine")
+	fs.StringVar(&engineSelection, "engine-select", selectByHash, "How to select the Dagger engine of the pool: hash (of the function name, for cache affinity) or load (fewest active sessions)")
+	fs.DurationVar(&engineTimeout, "engine-timeout", 5*time.Minute, "Maximum time to wait for the Dagger pod to be ready")
+}
+
+// getKubeClientset returns the clientset of the selected context
+func getKubeClientset() (*kubernetes.Clientset, error) {
+	clientConfig, err := getKubeClientConfig()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return kubernetes.NewForConfig(clientConfig)
+}
 
-	// TODO check if the pod is running
-
-	name := "dagger-engine"
+// setupRemoteEngine acquires an engine of the pool for the key (the function
+// name) and points the Dagger client at it, through a port forwarded to the
+// engine by this process.  The returned session must be released once done
+// with the engine, which also stops forwarding.
+func setupRemoteEngine(ctx context.Context, key string) (*engineSession, error) {
+	clientConfig, err := getKubeClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(clientConfig)
+	if err != nil {
+		return nil, err
+	}
 
-	var pod *v1.Pod
-	pod, err = clientset.CoreV1().Pods(kubeNamespace).Get(ctx, name, metav1.GetOptions{})
+	pod, session, err := acquireEngine(ctx, clientset, key)
+	if err != nil {
+		return nil, err
+	}
+	port, err := forwardEngine(ctx, clientConfig, clientset, pod.Name, session.stopForward)
+	if err != nil {
+		session.Release()
+		return nil, err
+	}
 
-	var perr *kerr.StatusError
+	// the SDK has no option selecting the engine, the CLI it runs reads the
+	// runner host from the environment
+	os.Setenv("_EXPERIMENTAL_DAGGER_RUNNER_HOST", engineRunnerHost(port))
+	return session, nil
+}
 
-	if ok := errors.As(err, &perr); ok && perr.ErrStatus.Reason == metav1.StatusReasonNotFound {
-		// if pod doesn't exist, create it
-		privileged := true
+// acquireEngine returns the ready pod of an engine of the pool for the key,
+// and the session of this invocation on it, once checked the namespace and
+// permissions allow remote builds.  An engine idle past its TTL is
+// first removed, then the engine is created, or reconciled, and an engine of
+// the pool selected, holding its Lease.  The engine is marked as used by this
+// pipeline, and removed on interrupt if created by this invocation.
+func acquireEngine(ctx context.Context, clientset kubernetes.Interface, key string) (*v1.Pod, *engineSession, error) {
+	if err := preflightEngine(ctx, clientset); err != nil {
+		return nil, nil, err
+	}
+	image, err := expectedEngineImage()
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg, err := loadEngineConfig()
+	if err != nil {
+		return nil, nil, err
+	}
 
-		pod, err = clientset.CoreV1().Pods(kubeNamespace).Create(ctx, &v1.Pod{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: name,
-			},
-			Spec: v1.PodSpec{
-				Containers: []v1.Container{
-					{
-						Name:            name,
-						Image:           "registry.dagger.io/engine:v0.3.13",
-						SecurityContext: &v1.SecurityContext{Privileged: &privileged},
-					},
-				},
-			},
-		}, metav1.CreateOptions{})
+	var session *engineSession
+	var created bool
+	err = withEngineLease(ctx, clientset, func(ctx context.Context) error {
+		if err := expireEngine(ctx, clientset); err != nil {
+			return err
+		}
+		var err error
+		if created, err = ensureEngine(ctx, clientset, image, cfg); err != nil {
+			return err
+		}
+		if err = reconcileEngine(ctx, clientset, image, cfg); err != nil {
+			return err
+		}
+		if err = touchEngine(ctx, clientset); err != nil {
+			return err
+		}
+		ordinal, err := selectEngine(ctx, clientset, key)
 		if err != nil {
 			return err
 		}
+		session, err = startEngineSession(ctx, clientset, enginePodName(ordinal))
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if created {
+		removeEngineOnInterrupt(clientset, session)
+	}
 
-		for {
-			pod, err = clientset.CoreV1().Pods(kubeNamespace).Get(ctx, pod.Name, metav1.GetOptions{})
-			if err != nil {
-				return err
-			}
-			fmt.Println("Waiting for pod to become ready: ", pod.Status.Phase)
-			if pod.Status.Phase == v1.PodRunning {
-				break
-			}
-
-			if pod.Status.Phase != v1.PodRunning && pod.Status.Phase != v1.PodPending {
-				return errors.New("error starting dagger engine pod")
-			}
-			// TODO change this to use client-go listeners instead of loops
-			time.Sleep(1 * time.Second)
-		}
+	pod, err := waitForPod(ctx, clientset, kubeNamespace, session.pod, image)
+	if err != nil {
+		session.Release()
+		return nil, nil, err
+	}
+	return pod, session, nil
+}
+
+// ensureEngine creates the headless Service and StatefulSet of the engine
+// with the given image and config, unless they exist.  Existing resources are
+// left as they are.  Returns whether the engine was created.
+func ensureEngine(ctx context.Context, clientset kubernetes.Interface, image string, cfg EngineConfig) (bool, error) {
+	_, err := clientset.CoreV1().Services(kubeNamespace).Create(ctx, engineService(), metav1.CreateOptions{})
+	if err != nil && !kerr.IsAlreadyExists(err) {
+		return false, err
+	}
+
+	_, err = clientset.AppsV1().StatefulSets(kubeNamespace).Get(ctx, engineName, metav1.GetOptions{})
+	if !kerr.IsNotFound(err) {
+		return false, err
+	}
+
+	sts, err := engineStatefulSet(image, cfg)
+	if err != nil {
+		return false, err
+	}
+	fmt.Printf("Creating Dagger engine StatefulSet '%v' in namespace '%v'\n", engineName, kubeNamespace)
+	_, err = clientset.AppsV1().StatefulSets(kubeNamespace).Create(ctx, sts, metav1.CreateOptions{})
+	if kerr.IsAlreadyExists(err) {
+		return false, nil
 	}
-	os.Setenv("_EXPERIMENTAL_DAGGER_RUNNER_HOST", fmt.Sprintf("kube-pod://%s?context=%s&namespace=%s&container=%s", pod.Name, kubeCfg.CurrentContext, kubeNamespace, pod.Spec.Containers[0].Name))
-	return nil
+	return err == nil, err
 }
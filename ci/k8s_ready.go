@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	kwatch "k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	kcache "k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+// engineTimeout is the maximum time to wait for the engine pod to be ready
+var engineTimeout time.Duration
+
+// failedWaitingReasons are the reasons of waiting containers from which the
+// pod does not recover without intervention.
+var failedWaitingReasons = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CrashLoopBackOff":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+	"RunContainerError":          true,
+}
+
+// errPodNotReady indicates the pod can not become ready
+type errPodNotReady struct {
+	reason string
+}
+
+func (e errPodNotReady) Error() string {
+	return e.reason
+}
+
+// waitForPod watches the pod until it runs the given image and all its
+// containers are ready, the pod fails, or the context (bounded by
+// engineTimeout) is done.  Pods being replaced are waited for, as they are
+// recreated by their controller.  On failure, the Kubernetes events of the
+// pod are included in the error.
+func waitForPod(ctx context.Context, clientset kubernetes.Interface, namespace, name, image string) (*v1.Pod, error) {
+	if engineTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, engineTimeout)
+		defer cancel()
+	}
+
+	selector := fields.OneTermEqualSelector("metadata.name", name).String()
+	lw := &kcache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			opts.FieldSelector = selector
+			return clientset.CoreV1().Pods(namespace).List(ctx, opts)
+		},
+		WatchFunc: func(opts metav1.ListOptions) (kwatch.Interface, error) {
+			opts.FieldSelector = selector
+			return clientset.CoreV1().Pods(namespace).Watch(ctx, opts)
+		},
+	}
+
+	lastPhase := v1.PodPhase("")
+	event, err := watchtools.UntilWithSync(ctx, lw, &v1.Pod{}, nil, func(e kwatch.Event) (bool, error) {
+		pod, ok := e.Object.(*v1.Pod)
+		if !ok || e.Type == kwatch.Deleted || pod.DeletionTimestamp != nil {
+			return false, nil
+		}
+		if !podRunsImage(pod, image) {
+			return false, nil
+		}
+		if pod.Status.Phase != lastPhase {
+			fmt.Println("Waiting for pod to become ready: ", pod.Status.Phase)
+			lastPhase = pod.Status.Phase
+		}
+		return podReady(pod)
+	})
+	if err == nil {
+		return event.Object.(*v1.Pod), nil
+	}
+
+	var notReady errPodNotReady
+	if !errors.As(err, &notReady) && ctx.Err() != nil {
+		err = fmt.Errorf("timed out after %v", engineTimeout)
+	}
+	err = fmt.Errorf("dagger engine pod '%v' is not ready: %w", name, err)
+
+	// the context may be done, so events are listed with a context of their own
+	eventsCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if events := podEvents(eventsCtx, clientset, namespace, name); events != "" {
+		err = fmt.Errorf("%w\nevents:\n%v", err, events)
+	}
+	return nil, err
+}
+
+// podReady returns whether all the containers of the pod are ready, or an
+// error if the pod failed or any of its containers are waiting for a reason
+// it does not recover from.
+func podReady(pod *v1.Pod) (bool, error) {
+	switch pod.Status.Phase {
+	case v1.PodFailed, v1.PodSucceeded:
+		reason := pod.Status.Reason
+		if reason == "" {
+			reason = "pod terminated"
+		}
+		return false, errPodNotReady{fmt.Sprintf("%v: %v %v", pod.Status.Phase, reason, pod.Status.Message)}
+	}
+
+	for _, cs := range pod.Status.ContainerStatuses {
+		if w := cs.State.Waiting; w != nil && failedWaitingReasons[w.Reason] {
+			return false, errPodNotReady{fmt.Sprintf("container '%v' is waiting: %v %v", cs.Name, w.Reason, w.Message)}
+		}
+	}
+
+	if pod.Status.Phase != v1.PodRunning || len(pod.Status.ContainerStatuses) == 0 {
+		return false, nil
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if !cs.Ready {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// podRunsImage returns whether the engine container of the pod runs the image
+func podRunsImage(pod *v1.Pod, image string) bool {
+	for _, c := range pod.Spec.Containers {
+		if c.Name == engineName {
+			return c.Image == image
+		}
+	}
+	return false
+}
+
+// podEvents returns the Kubernetes events of the pod, oldest first, one per
+// line.  Events which can not be listed are omitted.
+func podEvents(ctx context.Context, clientset kubernetes.Interface, namespace, name string) string {
+	list, err := clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.Set{
+			"involvedObject.kind": "Pod",
+			"involvedObject.name": name,
+		}.String(),
+	})
+	if err != nil {
+		return ""
+	}
+
+	events := list.Items
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].LastTimestamp.Before(&events[j].LastTimestamp)
+	})
+	var b strings.Builder
+	for _, e := range events {
+		fmt.Fprintf(&b, "  %v\t%v\t%v\n", e.Type, e.Reason, e.Message)
+	}
+	return b.String()
+}
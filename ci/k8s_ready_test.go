@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kwatch "k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+const (
+	readyTestImage = "registry.dagger.io/engine:v0.4.2"
+	readyTestPod   = "dagger-engine-0"
+)
+
+// enginePod returns the engine pod of the tests running image, in the given
+// phase with a single container of the given status.
+func enginePod(image string, phase v1.PodPhase, status *v1.ContainerStatus) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: readyTestPod, Namespace: kubeNamespace},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: engineName, Image: image}}},
+		Status:     v1.PodStatus{Phase: phase},
+	}
+	if status != nil {
+		status.Name = engineName
+		pod.Status.ContainerStatuses = []v1.ContainerStatus{*status}
+	}
+	return pod
+}
+
+// waiting returns the status of a container waiting for the given reason
+func waiting(reason string) *v1.ContainerStatus {
+	return &v1.ContainerStatus{State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: reason, Message: "details"}}}
+}
+
+func TestPodReady(t *testing.T) {
+	tests := []struct {
+		name    string
+		pod     *v1.Pod
+		ready   bool
+		wantErr string
+	}{
+		{name: "pending", pod: enginePod(readyTestImage, v1.PodPending, nil)},
+		{name: "creating", pod: enginePod(readyTestImage, v1.PodPending, waiting("ContainerCreating"))},
+		{name: "running without statuses", pod: enginePod(readyTestImage, v1.PodRunning, nil)},
+		{name: "running not ready", pod: enginePod(readyTestImage, v1.PodRunning, &v1.ContainerStatus{})},
+		{name: "running ready", pod: enginePod(readyTestImage, v1.PodRunning, &v1.ContainerStatus{Ready: true}), ready: true},
+		{name: "image pull back off", pod: enginePod(readyTestImage, v1.PodPending, waiting("ImagePullBackOff")), wantErr: "ImagePullBackOff"},
+		{name: "image pull error", pod: enginePod(readyTestImage, v1.PodPending, waiting("ErrImagePull")), wantErr: "ErrImagePull"},
+		{name: "crash loop back off", pod: enginePod(readyTestImage, v1.PodRunning, waiting("CrashLoopBackOff")), wantErr: "CrashLoopBackOff"},
+		{name: "failed", pod: enginePod(readyTestImage, v1.PodFailed, nil), wantErr: "Failed: pod terminated"},
+		{name: "succeeded", pod: enginePod(readyTestImage, v1.PodSucceeded, nil), wantErr: "Succeeded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ready, err := podReady(tt.pod)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("podReady() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ready != tt.ready {
+				t.Errorf("podReady() = %v, want %v", ready, tt.ready)
+			}
+		})
+	}
+}
+
+func TestPodRunsImage(t *testing.T) {
+	pod := enginePod(readyTestImage, v1.PodRunning, nil)
+	if !podRunsImage(pod, readyTestImage) {
+		t.Error("pod does not run the image of its engine container")
+	}
+	if podRunsImage(pod, "registry.dagger.io/engine:v0.4.1") {
+		t.Error("pod runs another image")
+	}
+	pod.Spec.Containers[0].Name = "sidecar"
+	if podRunsImage(pod, readyTestImage) {
+		t.Error("pod without an engine container runs the image")
+	}
+}
+
+// updatePodWhenWatched updates the pod of the clientset to the given one
+// once it is watched, repeatedly until the test ends, such that an update
+// racing the start of the watch is not missed.
+func updatePodWhenWatched(t *testing.T, clientset *fake.Clientset, pod *v1.Pod) {
+	watched := make(chan struct{})
+	var once sync.Once
+	clientset.PrependWatchReactor("pods", func(ktesting.Action) (bool, kwatch.Interface, error) {
+		once.Do(func() { close(watched) })
+		return false, nil, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() {
+		select {
+		case <-watched:
+		case <-ctx.Done():
+			return
+		}
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			clientset.CoreV1().Pods(kubeNamespace).Update(ctx, pod.DeepCopy(), metav1.UpdateOptions{})
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func TestWaitForPod(t *testing.T) {
+	defer func(timeout time.Duration) { engineTimeout = timeout }(engineTimeout)
+
+	event := &v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: readyTestPod + ".1", Namespace: kubeNamespace},
+		InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: readyTestPod, Namespace: kubeNamespace},
+		Type:           v1.EventTypeWarning,
+		Reason:         "Failed",
+		Message:        "Back-off pulling image",
+	}
+
+	tests := []struct {
+		name    string
+		initial *v1.Pod
+		update  *v1.Pod
+		events  []runtime.Object
+		wantErr []string
+	}{
+		{
+			name:    "becomes ready",
+			initial: enginePod(readyTestImage, v1.PodPending, waiting("ContainerCreating")),
+			update:  enginePod(readyTestImage, v1.PodRunning, &v1.ContainerStatus{Ready: true}),
+		},
+		{
+			name:    "replaced by the image",
+			initial: enginePod("registry.dagger.io/engine:v0.4.1", v1.PodRunning, &v1.ContainerStatus{Ready: true}),
+			update:  enginePod(readyTestImage, v1.PodRunning, &v1.ContainerStatus{Ready: true}),
+		},
+		{
+			name:    "fails to pull",
+			initial: enginePod(readyTestImage, v1.PodPending, waiting("ContainerCreating")),
+			update:  enginePod(readyTestImage, v1.PodPending, waiting("ImagePullBackOff")),
+			events:  []runtime.Object{event},
+			wantErr: []string{"ImagePullBackOff", "Back-off pulling image"},
+		},
+		{
+			name:    "times out running another image",
+			initial: enginePod("registry.dagger.io/engine:v0.4.1", v1.PodRunning, &v1.ContainerStatus{Ready: true}),
+			events:  []runtime.Object{event},
+			wantErr: []string{"timed out", "Back-off pulling image"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engineTimeout = 5 * time.Second
+			if tt.update == nil {
+				engineTimeout = 200 * time.Millisecond
+			}
+			clientset := fake.NewSimpleClientset(append(tt.events, tt.initial)...)
+			if tt.update != nil {
+				updatePodWhenWatched(t, clientset, tt.update)
+			}
+
+			pod, err := waitForPod(context.Background(), clientset, kubeNamespace, readyTestPod, readyTestImage)
+			if len(tt.wantErr) > 0 {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				for _, want := range tt.wantErr {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("error %q does not contain %q", err, want)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ready, _ := podReady(pod); !ready || !podRunsImage(pod, readyTestImage) {
+				t.Errorf("waitForPod() returned a pod which is not ready with the image: %+v", pod.Status)
+			}
+		})
+	}
+}
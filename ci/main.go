@@ -19,6 +19,12 @@ func main() {
 	case "build":
 		err = build(ctx)
 
+	case "test":
+		err = test(ctx)
+
+	case "run":
+		err = run(ctx)
+
 	case "scan-local":
 		err = scan(ctx, "dir:.")
 
@@ -28,6 +34,24 @@ func main() {
 	case "push":
 		err = push(ctx)
 
+	case "verify-reproducible":
+		err = verifyReproducible(ctx)
+
+	case "sign":
+		err = sign(ctx)
+
+	case "verify":
+		err = verify(ctx)
+
+	case "deploy":
+		err = deploy(ctx)
+
+	case "cache":
+		err = cache(ctx)
+
+	case "engine":
+		err = engine(ctx)
+
 	default:
 		log.Fatalln("invalid command specified")
 	}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+// goVersions is a comma separated list of Go versions against which to run
+// a matrix, each in its own container.
+var goVersions string
+
+// matrixResult is the outcome of running a command with a single Go version
+type matrixResult struct {
+	Version  string
+	Duration time.Duration
+	Err      error
+}
+
+// runGo runs the Go command on the source with the Go version of go.mod (or
+// the version given by --go-version), or with each of the versions given by
+// --go-versions if set.
+func runGo(ctx context.Context, c *dagger.Client, fn Function, src *dagger.Directory, cmd []string) error {
+	if goVersions == "" {
+		if err := runGoVersion(ctx, c, fn, src, goVersion, cmd); err != nil {
+			return errors.New(err.Error())
+		}
+		return nil
+	}
+
+	var versions []string
+	for _, v := range strings.Split(goVersions, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			versions = append(versions, v)
+		}
+	}
+	return goMatrix(ctx, c, fn, src, versions, cmd)
+}
+
+// goMatrix runs the Go command on the source concurrently for each of the
+// given Go versions, then reports a table of the results.  Returns an error
+// if any of the versions failed.
+func goMatrix(ctx context.Context, c *dagger.Client, fn Function, src *dagger.Directory, versions []string, cmd []string) error {
+	results := make([]matrixResult, len(versions))
+
+	var wg sync.WaitGroup
+	for i, v := range versions {
+		wg.Add(1)
+		go func(i int, v string) {
+			defer wg.Done()
+			start := time.Now()
+			results[i] = matrixResult{Version: v, Err: runGoVersion(ctx, c, fn, src, v, cmd)}
+			results[i].Duration = time.Since(start).Round(time.Second)
+		}(i, v)
+	}
+	wg.Wait()
+
+	var failed []string
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "GO VERSION\tRESULT\tDURATION\n")
+	for _, r := range results {
+		result := "pass"
+		if r.Err != nil {
+			result = "FAIL"
+			failed = append(failed, r.Version)
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\n", r.Version, result, r.Duration)
+	}
+	w.Flush()
+
+	for _, r := range results {
+		if r.Err != nil {
+			fmt.Printf("\nGo %v: %v\n", r.Version, r.Err)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("'%v' failed for Go versions: %v", strings.Join(cmd, " "), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// runGoVersion runs the Go command on the source with a single Go version,
+// defaulting to the version required by go.mod.
+func runGoVersion(ctx context.Context, c *dagger.Client, fn Function, src *dagger.Directory, version string, cmd []string) error {
+	goImage, err := goToolchainImage(ctx, src, version)
+	if err != nil {
+		return err
+	}
+	ctr := getGoContainer(c, fn, goImage)
+	if err = checkGoContainer(ctx, ctr, goImage); err != nil {
+		return err
+	}
+	_, err = ctr.WithMountedDirectory("/app", src).
+		WithExec(cmd).
+		ExitCode(ctx)
+	return err
+}
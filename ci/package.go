@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"sync"
+	"time"
 
 	"dagger.io/dagger"
 )
@@ -12,12 +15,20 @@ import (
 var (
 	remote        bool
 	kubeNamespace string
+	platforms     string
 )
 
 func pkg(ctx context.Context, push bool) error {
 	fs := flag.NewFlagSet("package", flag.ExitOnError)
 	fs.BoolVar(&remote, "remote", false, "Performs remote build")
-	fs.StringVar(&kubeNamespace, "kube-namespace", "default", "Kube namespace to create the Dagger pod")
+	addKubeFlags(fs)
+	fs.StringVar(&platforms, "platforms", "", "Comma separated list of platforms to build a multi-arch image for (e.g. linux/amd64,linux/arm64), each also published to a tag suffixed with the platform (e.g. latest-linux-arm64). Requires --remote, and a multi-arch builder image for platforms other than linux/amd64")
+	fs.BoolVar(&force, "force", false, "Packages even if the function is unchanged since the last successful package")
+	addGoModulesFlags(fs)
+	fs.StringVar(&cacheImage, "cache-image", "", "Image in which to store the cache of buildpack layers (e.g. registry.example.com/fn-cache), instead of a cache volume of the engine. Requires --remote")
+	fs.StringVar(&cacheImport, "cache-import", "", "Tarball of the build caches to restore before building. Requires --remote")
+	fs.StringVar(&cacheExport, "cache-export", "", "Path to which a tarball of the build caches is written after building. Requires --remote")
+	fs.BoolVar(&fromGit, "git", false, "Packages the source from the function's git repository and revision (build.git) instead of the local directory")
 	fs.Parse(os.Args[2:])
 
 	fn, err := NewFunction(".")
@@ -25,6 +36,60 @@ func pkg(ctx context.Context, push bool) error {
 		return err
 	}
 
+	if err = exportGoProxy(); err != nil {
+		return err
+	}
+	if usesGoModulesAuth() && !remote {
+		fmt.Println("Warning: private Go module access is only configured for remote builds")
+	}
+
+	// pushing and packaging are tracked separately, such that packaging
+	// locally does not skip a later push
+	stage := "package"
+	if push {
+		stage = "push"
+	}
+	extra := append([]string{fmt.Sprintf("remote=%v", remote), "platforms=" + platforms, fmt.Sprintf("git=%v", fromGit), "cache-image=" + cacheImage},
+		goModulesFingerprint()...)
+	fingerprint, err := fn.Fingerprint(extra...)
+	if err != nil {
+		return err
+	}
+	platformList, err := parsePlatforms(platforms)
+	if err != nil {
+		return err
+	}
+	if len(platformList) > 0 && !remote {
+		return errors.New("building for multiple platforms requires --remote")
+	}
+	if (cacheImage != "" || cacheImport != "" || cacheExport != "") && !remote {
+		return errCacheRemote
+	}
+
+	if !force && isUpToDate(fn, stage, fingerprint) {
+		fmt.Printf("Function unchanged since the last successful %v, skipping (use --force to %v anyway)\n", stage, stage)
+		if cacheExport != "" {
+			// the caches of the last build are exported all the same
+			return exportRemotePackCaches(ctx, fn, platformList, cacheExport)
+		}
+		return nil
+	}
+
+	// the published image is built from, and attested to, a single commit
+	if fromGit && !pinnedGitRevision(fn.Build.Git) {
+		if fn.Build.Git.Revision, err = resolveGitRevision(fn.Build.Git); err != nil {
+			return err
+		}
+		fmt.Printf("Building revision %v\n", fn.Build.Git.Revision)
+	}
+
+	// images to be scanned once built, one per platform
+	images := []string{fn.Image}
+
+	// digest of the published image, if any
+	var digest string
+	started := time.Now()
+
 	if remote {
 
 		fmt.Println("Starting remote build")
@@ -34,46 +99,52 @@ func pkg(ctx context.Context, push bool) error {
 			return err
 		}
 
-		err = setupRemoteEngine(ctx)
+		session, err := setupRemoteEngine(ctx, fn.Name)
 		if err != nil {
 			return err
 		}
+		defer session.Release()
 
 		c := getDaggerClient(ctx)
 		defer c.Close()
 
-		appDir := c.Host().Directory(".", dagger.HostDirectoryOpts{})
+		appDir, err := functionSource(c, fn)
+		if err != nil {
+			return err
+		}
 
 		dockerConfig := c.Host().Directory("/home/marcos/.docker/", dagger.HostDirectoryOpts{}).File("config.json")
-		layersCache := c.CacheVolume("packs_layers")
-		platformCache := c.CacheVolume("packs_platform")
-		cacheDir := c.CacheVolume("packs_cache")
-
-		_, err = c.Container().WithUser("root").From(buildImage).
-			WithMountedDirectory("/workspace", appDir).
-			WithMountedFile("/workspace/config.json", dockerConfig).
-			WithMountedCache("/layers", layersCache).
-			WithMountedCache("/platform", platformCache).
-			WithMountedCache("/workspace/cache", cacheDir).
-			WithUser("root").
-			WithExec([]string{"chown", "-R", "1000:1000", "/workspace", "/layers", "/platform"}).
-			WithUser("cnb").
-			WithEnvVariable("DOCKER_CONFIG", "/workspace").
-			WithExec([]string{
-				"/cnb/lifecycle/creator",
-				"-cache-dir=/workspace/cache",
-				fn.Image,
-			}).ExitCode(ctx)
+
+		if cacheImport != "" {
+			if err = importPackCaches(ctx, c, fn, platformList, cacheImport); err != nil {
+				return err
+			}
+		}
+
+		if len(platformList) == 0 {
+			digest, err = packBuild(ctx, c, fn, buildImage, appDir, dockerConfig, "", fn.Image)
+		} else {
+			images, digest, err = packBuildPlatforms(ctx, c, fn, buildImage, appDir, dockerConfig, platformList, fn.Image)
+		}
 		if err != nil {
 			return err
 		}
 
+		if cacheExport != "" {
+			if err = exportPackCaches(ctx, c, fn, platformList, cacheExport); err != nil {
+				return err
+			}
+		}
+
 	} else {
 		c := getDaggerClient(ctx)
 
 		defer c.Close()
 
-		appDir := c.Host().Directory(".", dagger.HostDirectoryOpts{})
+		appDir, err := functionSource(c, fn)
+		if err != nil {
+			return err
+		}
 
 		dockerSock := c.Host().UnixSocket("/var/run/docker.sock")
 		funcBinary := c.Host().Directory("/home/marcos/Projects/func").File("func")
@@ -85,23 +156,141 @@ func pkg(ctx context.Context, push bool) error {
 			buildCmd = append(buildCmd, "-u")
 		}
 
-		_, err = c.Container().From("alpine").WithMountedFile("/func", funcBinary).
+		built := c.Container().From("alpine").WithMountedFile("/func", funcBinary).
 			WithEntrypoint([]string{"/func"}).
 			WithUnixSocket("/var/run/docker.sock", dockerSock).
 			WithMountedSecret("/root/.docker/config.json", dockerConfig).
 			WithMountedDirectory("/app", appDir).
 			WithWorkdir("/app").
-			WithExec(buildCmd).ExitCode(ctx)
+			WithExec(buildCmd)
+		_, err = built.ExitCode(ctx)
+		if err != nil {
+			return err
+		}
+
+		if push {
+			// the func CLI records the digest of the pushed image in func.yaml
+			digest, err = builtImageDigest(ctx, built.File("/app/"+FunctionFile))
+			if err != nil {
+				return err
+			}
+		}
+
+	}
+
+	if remote || push {
+		err = writeProvenance(fn, digest, started, time.Now())
 		if err != nil {
 			return err
 		}
 
+		// record the digest of the published image, such that it can be
+		// signed and deployed by digest
+		if err = fn.RecordPublished(digest); err != nil {
+			return err
+		}
 	}
 
-	err = scan(ctx, fn.Image)
+	for _, image := range images {
+		err = scan(ctx, image)
+		if err != nil {
+			return err
+		}
+	}
+
+	return fn.Stamp(stage, fingerprint)
+}
+
+// packBuild builds the function with the CNB lifecycle of the builder image
+// and publishes the resulting image, returning its digest.  An empty platform
+// builds for the default platform of the engine.
+func packBuild(ctx context.Context, c *dagger.Client, fn Function, buildImage string, appDir *dagger.Directory, dockerConfig *dagger.File, platform dagger.Platform, image string) (string, error) {
+	layersCache := functionCache(c, fn, packCacheName("packs_layers", platform))
+	platformCache := functionCache(c, fn, packCacheName("packs_platform", platform))
+	cacheDir := functionCache(c, fn, packCacheName("packs_cache", platform))
+
+	// the lifecycle uses the cache image instead of the cache directory
+	cacheOpt := "-cache-dir=/workspace/cache"
+	cacheImageRef, err := packCacheImage(platform)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if cacheImageRef != "" {
+		cacheOpt = "-cache-image=" + cacheImageRef
 	}
 
-	return nil
+	ctr := c.Container(dagger.ContainerOpts{Platform: platform}).WithUser("root").From(buildImage).
+		WithMountedDirectory("/workspace", appDir).
+		WithMountedFile("/workspace/config.json", dockerConfig).
+		WithMountedCache("/layers", layersCache).
+		WithMountedCache("/platform", platformCache).
+		WithMountedCache("/workspace/cache", cacheDir).
+		WithUser("root").
+		WithExec([]string{"chown", "-R", "1000:1000", "/workspace", "/layers", "/platform"})
+
+	report, err := withPackCreator(c, ctr.WithEnvVariable("DOCKER_CONFIG", "/workspace"), []string{
+		"/cnb/lifecycle/creator",
+		cacheOpt,
+		"-report=" + packReport,
+		image,
+	}).File(packReport).Contents(ctx)
+	if err != nil {
+		return "", err
+	}
+	return packReportDigest(report)
+}
+
+// packBuildPlatforms builds the function concurrently for each of the given
+// platforms, publishing each one to its own platform tag, and then publishes
+// a multi-platform index of them all to image.
+// Returns the references of the images of each platform, and the digest of
+// the index.  The platform tags are left in the registry: the index refers
+// to their manifests, which can not be deleted without breaking it, and
+// registries do not delete tags alone.
+func packBuildPlatforms(ctx context.Context, c *dagger.Client, fn Function, buildImage string, appDir *dagger.Directory, dockerConfig *dagger.File, platforms []dagger.Platform, image string) ([]string, string, error) {
+	if err := checkBuilderPlatforms(buildImage, platforms); err != nil {
+		return nil, "", err
+	}
+	images := make([]string, len(platforms))
+	for i, p := range platforms {
+		var err error
+		if images[i], err = platformImage(image, p); err != nil {
+			return nil, "", err
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(platforms))
+	for i, p := range platforms {
+		wg.Add(1)
+		go func(i int, p dagger.Platform) {
+			defer wg.Done()
+			fmt.Printf("Building %v for platform %v\n", images[i], p)
+			if _, err := packBuild(ctx, c, fn, buildImage, appDir, dockerConfig, p, images[i]); err != nil {
+				errs[i] = fmt.Errorf("platform %v: %w", p, err)
+			}
+		}(i, p)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, "", err
+	}
+
+	variants := make([]*dagger.Container, len(platforms))
+	for i, p := range platforms {
+		variants[i] = c.Container(dagger.ContainerOpts{Platform: p}).From(images[i])
+	}
+	ref, err := c.Container().Publish(ctx, image, dagger.ContainerPublishOpts{
+		PlatformVariants: variants,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	fmt.Println("Published multi-platform image: ", ref)
+
+	published, err := ParseImageReference(ref)
+	if err != nil {
+		return nil, "", err
+	}
+	return images, published.Digest, nil
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// regPlatform matches a platform in the form os/arch[/variant]
+var regPlatform = regexp.MustCompile(`^[a-z0-9]+/[a-z0-9_]+(?:/[a-z0-9]+)?$`)
+
+// parsePlatforms parses a comma separated list of platforms, ex.
+// 'linux/amd64,linux/arm64/v8'.  An empty list results in no platforms,
+// meaning the default platform of the engine.
+func parsePlatforms(s string) (platforms []dagger.Platform, err error) {
+	seen := map[string]bool{}
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !regPlatform.MatchString(p) {
+			return nil, fmt.Errorf("platform '%v' is not valid, expected form 'os/arch[/variant]'", p)
+		}
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		platforms = append(platforms, dagger.Platform(p))
+	}
+	return
+}
+
+// platformImage returns the reference to which the image of a single
+// platform is published before being assembled into a multi-platform index.
+// The platform is appended to the tag of the image, ex.
+// 'quay.io/alice/fn:latest' for 'linux/arm64' is 'quay.io/alice/fn:latest-linux-arm64'
+func platformImage(image string, platform dagger.Platform) (string, error) {
+	ref, err := ParseImageReference(image)
+	if err != nil {
+		return "", err
+	}
+	if ref.Tag == "" {
+		ref.Tag = "latest"
+	}
+	ref.Tag += "-" + platformSlug(platform)
+	ref.Digest = ""
+	return ref.String(), nil
+}
+
+// platformSlug returns the platform in a form usable in tags and cache
+// volume names, ex. 'linux-arm64-v8'
+func platformSlug(platform dagger.Platform) string {
+	return strings.ReplaceAll(string(platform), "/", "-")
+}
+
+// checkBuilderPlatforms checks the builder image can build for the platforms.
+// The default builder images are amd64 only, so building for another
+// platform would run their amd64 lifecycle emulated, and publish amd64
+// images under the tag of the platform.  Builders configured in the function
+// (build.builderImages) are expected to be multi-arch.
+func checkBuilderPlatforms(builder string, platforms []dagger.Platform) error {
+	for _, image := range DefaultBuilderImages {
+		if defaultImage, err := builderImage(image); err != nil || defaultImage != builder {
+			continue
+		}
+		for _, p := range platforms {
+			if goos, goarch, _ := goPlatform(p); goos != "linux" || goarch != "amd64" {
+				return fmt.Errorf("the default builder image %v is amd64 only and can not build for platform %v, configure a multi-arch builder image in build.builderImages", builder, p)
+			}
+		}
+		return nil
+	}
+	return nil
+}
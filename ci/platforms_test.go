@@ -0,0 +1,103 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"dagger.io/dagger"
+)
+
+func TestParsePlatforms(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []dagger.Platform
+		wantErr bool
+	}{
+		{in: ""},
+		{in: " , "},
+		{in: "linux/amd64", want: []dagger.Platform{"linux/amd64"}},
+		{in: "linux/amd64, linux/arm64/v8", want: []dagger.Platform{"linux/amd64", "linux/arm64/v8"}},
+		{in: "linux/amd64,linux/arm64,linux/amd64", want: []dagger.Platform{"linux/amd64", "linux/arm64"}},
+		{in: "linux/arm/v7,,linux/arm/v7", want: []dagger.Platform{"linux/arm/v7"}},
+		{in: "linux", wantErr: true},
+		{in: "Linux/AMD64", wantErr: true},
+		{in: "linux/amd64/v1/extra", wantErr: true},
+		{in: "linux/amd64,linux arm64", wantErr: true},
+		{in: "/amd64", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parsePlatforms(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parsePlatforms(%q) = %v, expected an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parsePlatforms(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parsePlatforms(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPlatformImage(t *testing.T) {
+	digest := "sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		image    string
+		platform dagger.Platform
+		want     string
+		wantErr  bool
+	}{
+		{image: "quay.io/alice/fn:latest", platform: "linux/arm64", want: "quay.io/alice/fn:latest-linux-arm64"},
+		{image: "quay.io/alice/fn", platform: "linux/amd64", want: "quay.io/alice/fn:latest-linux-amd64"},
+		{image: "quay.io/alice/fn:v1.2.3", platform: "linux/arm/v7", want: "quay.io/alice/fn:v1.2.3-linux-arm-v7"},
+		{image: "quay.io/alice/fn@" + digest, platform: "linux/arm64", want: "quay.io/alice/fn:latest-linux-arm64"},
+		{image: "quay.io/alice/fn:v1@" + digest, platform: "linux/arm64", want: "quay.io/alice/fn:v1-linux-arm64"},
+		{image: "localhost:5000/alice/fn", platform: "linux/arm64", want: "localhost:5000/alice/fn:latest-linux-arm64"},
+		{image: "localhost:5000/fn:dev", platform: "linux/amd64", want: "localhost:5000/fn:dev-linux-amd64"},
+		{image: "Quay.io/Alice/FN", platform: "linux/amd64", wantErr: true},
+		{image: "", platform: "linux/amd64", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := platformImage(tt.image, tt.platform)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("platformImage(%q, %v) = %v, expected an error", tt.image, tt.platform, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("platformImage(%q, %v): %v", tt.image, tt.platform, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("platformImage(%q, %v) = %v, want %v", tt.image, tt.platform, got, tt.want)
+		}
+	}
+}
+
+func TestCheckBuilderPlatforms(t *testing.T) {
+	paketo, err := builderImage(DefaultBuilderImages["go"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		builder   string
+		platforms []dagger.Platform
+		wantErr   bool
+	}{
+		{builder: paketo, platforms: []dagger.Platform{"linux/amd64"}},
+		{builder: paketo, platforms: []dagger.Platform{"linux/amd64", "linux/arm64"}, wantErr: true},
+		{builder: paketo, platforms: []dagger.Platform{"linux/arm/v7"}, wantErr: true},
+		{builder: "docker.io/paketobuildpacks/builder-jammy-multi-arch:latest", platforms: []dagger.Platform{"linux/amd64", "linux/arm64"}},
+	}
+	for _, tt := range tests {
+		err := checkBuilderPlatforms(tt.builder, tt.platforms)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkBuilderPlatforms(%v, %v) = %v, want error %v", tt.builder, tt.platforms, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+const (
+	// enginePort is the TCP port the engine listens on, in addition to its
+	// unix socket, such that it can be reached by port forwarding.
+	enginePort = 1234
+
+	// engineSocket is the unix socket of the engine
+	engineSocket = "unix:///var/run/buildkit/buildkitd.sock"
+)
+
+// engineArgs are the arguments of the engine container.  The engine port has
+// neither TLS nor authentication, and a build on the privileged engine is
+// root on its node, so it is bound to the loopback interface of the pod:
+// port forwarding connects from within the network namespace of the pod,
+// while other pods of the cluster can not connect at all.
+func engineArgs() []string {
+	return []string{
+		"--addr", engineSocket,
+		"--addr", fmt.Sprintf("tcp://127.0.0.1:%d", enginePort),
+	}
+}
+
+// forwardEngine forwards a local port of the loopback interface to the
+// engine port of the pod, over the API server with SPDY, until stop is
+// closed or the context is done.  Returns the local port.
+func forwardEngine(ctx context.Context, config *rest.Config, clientset kubernetes.Interface, pod string, stop chan struct{}) (uint16, error) {
+	transport, upgrader, err := spdy.RoundTripperFor(config)
+	if err != nil {
+		return 0, err
+	}
+	req := clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(kubeNamespace).
+		Name(pod).
+		SubResource("portforward")
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, req.URL())
+
+	// errors of the forwarded connections are reported by the Dagger client
+	ready := make(chan struct{})
+	fw, err := portforward.NewOnAddresses(dialer, []string{"127.0.0.1"}, []string{fmt.Sprintf("0:%d", enginePort)}, stop, ready, io.Discard, io.Discard)
+	if err != nil {
+		return 0, err
+	}
+
+	failed := make(chan error, 1)
+	go func() {
+		failed <- fw.ForwardPorts()
+	}()
+
+	select {
+	case <-ready:
+	case err = <-failed:
+		if err == nil {
+			err = errors.New("port forwarding stopped")
+		}
+		return 0, fmt.Errorf("forwarding to Dagger engine '%v': %w", pod, err)
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+
+	ports, err := fw.GetPorts()
+	if err != nil {
+		return 0, err
+	}
+	if len(ports) != 1 {
+		return 0, fmt.Errorf("forwarding to Dagger engine '%v': expected a single port, got %d", pod, len(ports))
+	}
+	return ports[0].Local, nil
+}
+
+// engineRunnerHost returns the runner host of the Dagger CLI for the local
+// port forwarded to the engine.
+func engineRunnerHost(port uint16) string {
+	return fmt.Sprintf("tcp://127.0.0.1:%d", port)
+}
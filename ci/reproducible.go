@@ -0,0 +1,317 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+// ReproducibilityReport is the file in DistDir to which the result of
+// verifying the build is reproducible is written.
+const ReproducibilityReport = "reproducibility.txt"
+
+// sourceDateEpoch is the SOURCE_DATE_EPOCH of reproducible builds
+var sourceDateEpoch int64
+
+func verifyReproducible(ctx context.Context) error {
+	fs := flag.NewFlagSet("verify-reproducible", flag.ExitOnError)
+	fs.StringVar(&mainPackage, "main", "", "Main package to build and compare, defaults to a main package scaffolded to serve the function")
+	fs.StringVar(&goVersion, "go-version", "", "Go version with which to build (e.g. 1.20.1), defaults to the version required by go.mod")
+	fs.Int64Var(&sourceDateEpoch, "source-date-epoch", -1, "SOURCE_DATE_EPOCH of the builds, defaults to the time of the commit being built")
+	fs.BoolVar(&fromGit, "git", false, "Verifies the source from the function's git repository and revision (build.git) instead of the local directory")
+	addGoModulesFlags(fs)
+	fs.Parse(os.Args[2:])
+
+	if err := exportGoProxy(); err != nil {
+		return err
+	}
+
+	fn, err := NewFunction(".")
+	if err != nil {
+		return err
+	}
+	if fn.Name == "" {
+		return ErrNameRequired
+	}
+	if sourceDateEpoch < 0 {
+		sourceDateEpoch = commitTime(fn)
+	}
+
+	c := getDaggerClient(ctx)
+	defer c.Close()
+
+	appDir, err := functionSource(c, fn)
+	if err != nil {
+		return err
+	}
+	goImage, err := goToolchainImage(ctx, appDir, goVersion)
+	if err != nil {
+		return err
+	}
+	ctr := getGoContainer(c, fn, goImage)
+	if err = checkGoContainer(ctx, ctr, goImage); err != nil {
+		return err
+	}
+	src, pkg, err := mainSource(ctx, ctr, appDir)
+	if err != nil {
+		return err
+	}
+
+	// build twice, concurrently, each busting the cache of the other
+	nonce := time.Now().UnixNano()
+	var wg sync.WaitGroup
+	digests := make([]map[string]string, 2)
+	errs := make([]error, 2)
+	for i := range digests {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			out := reproducibleBuild(c, fn, src, pkg, goImage, i, nonce)
+			if digests[i], errs[i] = buildDigests(ctx, c, fn, out); errs[i] != nil {
+				errs[i] = fmt.Errorf("build #%d: %w", i+1, errs[i])
+			}
+		}(i)
+	}
+	wg.Wait()
+	if err = errors.Join(errs...); err != nil {
+		return err
+	}
+
+	report, differing := compareDigests(digests[0], digests[1])
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "function:          %v\n", fn.Name)
+	fmt.Fprintf(&b, "commit:            %v\n", sourceCommit(fn))
+	fmt.Fprintf(&b, "package:           %v\n", pkg)
+	fmt.Fprintf(&b, "toolchain:         %v\n", goImage)
+	fmt.Fprintf(&b, "SOURCE_DATE_EPOCH: %v\n\n", sourceDateEpoch)
+	b.WriteString(report)
+	if len(differing) == 0 {
+		b.WriteString("\nresult: reproducible\n")
+	} else {
+		fmt.Fprintf(&b, "\nresult: NOT reproducible, %d differing files\n", len(differing))
+	}
+	fmt.Print(b.String())
+
+	path := filepath.Join(fn.Root, DistDir, ReproducibilityReport)
+	if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err = os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		return err
+	}
+	fmt.Println("Report written to", path)
+
+	if len(differing) > 0 {
+		return fmt.Errorf("build is not reproducible, differing files: %v", strings.Join(differing, ", "))
+	}
+	return nil
+}
+
+// reproducibleBuild builds the main package of the source in a container of
+// its own, returning the directory of the output.  Each build uses a cold
+// build cache and a different source path, such that neither a shared cache
+// nor the location of the source can mask differences between builds.  The
+// build number and nonce bust the cache of the engine, which would otherwise
+// serve the second build from the first.  The binary is dated to the
+// SOURCE_DATE_EPOCH, such that images of it can be compared.
+func reproducibleBuild(c *dagger.Client, fn Function, src *dagger.Directory, pkg, goImage string, n int, nonce int64) *dagger.Directory {
+	dir := fmt.Sprintf("/build-%d/%v", n+1, fn.Name)
+	epoch := strconv.FormatInt(sourceDateEpoch, 10)
+	return getGoContainer(c, fn, goImage).
+		WithMountedDirectory(dir, src).
+		WithWorkdir(dir).
+		WithEnvVariable("REPRODUCIBLE_BUILD", fmt.Sprintf("%d-%d", n+1, nonce)).
+		WithEnvVariable("GOCACHE", "/tmp/gocache").
+		WithEnvVariable("CGO_ENABLED", "0").
+		WithEnvVariable("SOURCE_DATE_EPOCH", epoch).
+		WithExec([]string{"go", "build", "-trimpath", "-o", "/out/" + fn.Name, pkg}).
+		WithExec([]string{"touch", "-d", "@" + epoch, "/out/" + fn.Name}).
+		Directory("/out")
+}
+
+// buildDigests returns the digests of the files of the output of a build,
+// and of the layers of an image of its binary.
+func buildDigests(ctx context.Context, c *dagger.Client, fn Function, out *dagger.Directory) (map[string]string, error) {
+	digests, err := fileDigests(ctx, c, out)
+	if err != nil {
+		return nil, err
+	}
+	image := c.Container().
+		WithRootfs(c.Directory().WithFile(fn.Name, out.File(fn.Name))).
+		WithEntrypoint([]string{"/" + fn.Name})
+	layers, err := imageLayerDigests(ctx, image)
+	if err != nil {
+		return nil, fmt.Errorf("image: %w", err)
+	}
+	for i, layer := range layers {
+		digests[fmt.Sprintf("image layer #%d", i+1)] = layer
+	}
+	return digests, nil
+}
+
+// imageLayerDigests returns the digests of the layers of a container, by
+// exporting it as an OCI tarball.
+func imageLayerDigests(ctx context.Context, ctr *dagger.Container) ([]string, error) {
+	dir, err := os.MkdirTemp("", "reproducible")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tarball := filepath.Join(dir, "image.tar")
+	if _, err = ctr.Export(ctx, tarball); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(tarball)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ociLayerDigests(f)
+}
+
+// ociDescriptor is the part of OCI descriptors, indexes and manifests needed
+// to walk from the index of an image layout to the layers of its manifest.
+type ociDescriptor struct {
+	MediaType string          `json:"mediaType"`
+	Digest    string          `json:"digest"`
+	Manifests []ociDescriptor `json:"manifests"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+// ociLayerDigests returns the digests of the layers of the single image of an
+// OCI image layout tarball.
+func ociLayerDigests(r io.Reader) ([]string, error) {
+	// blobs other than layers are small JSON documents
+	const maxDocument = 1 << 20
+	docs := map[string][]byte{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg || hdr.Size > maxDocument {
+			continue
+		}
+		if docs[path.Clean(hdr.Name)], err = io.ReadAll(tr); err != nil {
+			return nil, err
+		}
+	}
+
+	doc, ok := docs["index.json"]
+	for depth := 0; ok && depth < 3; depth++ {
+		var d ociDescriptor
+		if err := json.Unmarshal(doc, &d); err != nil {
+			return nil, err
+		}
+		if len(d.Layers) > 0 {
+			layers := make([]string, len(d.Layers))
+			for i, l := range d.Layers {
+				layers[i] = l.Digest
+			}
+			return layers, nil
+		}
+		if len(d.Manifests) != 1 {
+			return nil, fmt.Errorf("expected a single manifest, found %d", len(d.Manifests))
+		}
+		doc, ok = docs["blobs/"+strings.Replace(d.Manifests[0].Digest, ":", "/", 1)]
+	}
+	return nil, errors.New("image has no manifest with layers")
+}
+
+// fileDigests returns the SHA256 digests of the files of a directory by
+// their path relative to it.
+func fileDigests(ctx context.Context, c *dagger.Client, dir *dagger.Directory) (map[string]string, error) {
+	out, err := c.Container().From("alpine").
+		WithMountedDirectory("/dir", dir).
+		WithWorkdir("/dir").
+		WithExec([]string{"sh", "-c", "find . -type f -exec sha256sum {} +"}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	digests := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		digests[strings.TrimPrefix(fields[1], "./")] = fields[0]
+	}
+	return digests, nil
+}
+
+// compareDigests returns a report of the digests of two builds by file, and
+// the files which differ between them (including files missing from either).
+func compareDigests(a, b map[string]string) (report string, differing []string) {
+	files := map[string]bool{}
+	for f := range a {
+		files[f] = true
+	}
+	for f := range b {
+		files[f] = true
+	}
+	names := make([]string, 0, len(files))
+	for f := range files {
+		names = append(names, f)
+	}
+	sort.Strings(names)
+
+	var s strings.Builder
+	for _, f := range names {
+		da, db := a[f], b[f]
+		if da == "" {
+			da = "missing"
+		}
+		if db == "" {
+			db = "missing"
+		}
+		result := "identical"
+		if da != db {
+			result = "DIFFERS"
+			differing = append(differing, f)
+		}
+		fmt.Fprintf(&s, "%v: %v\n  build #1: %v\n  build #2: %v\n", f, result, da, db)
+	}
+	return s.String(), differing
+}
+
+// commitTime returns the time of the commit being built as seconds since the
+// epoch, or 0 if unknown.
+func commitTime(fn Function) int64 {
+	if fromGit {
+		return 0
+	}
+	cmd := exec.Command("git", "log", "-1", "--format=%ct")
+	cmd.Dir = fn.Root
+	out, err := cmd.Output()
+	if err != nil {
+		return 0
+	}
+	t, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return t
+}
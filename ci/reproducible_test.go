@@ -0,0 +1,93 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestOCILayerDigests(t *testing.T) {
+	const (
+		manifest = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+		layer1   = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+		layer2   = "sha256:3333333333333333333333333333333333333333333333333333333333333333"
+		nested   = "sha256:4444444444444444444444444444444444444444444444444444444444444444"
+	)
+	manifestDoc := `{"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[{"digest":"` + layer1 + `"},{"digest":"` + layer2 + `"}]}`
+
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "index of a manifest",
+			files: map[string]string{
+				"index.json":                   `{"manifests":[{"digest":"` + manifest + `"}]}`,
+				"blobs/sha256/" + manifest[7:]: manifestDoc,
+			},
+			want: []string{layer1, layer2},
+		},
+		{
+			name: "index of an index",
+			files: map[string]string{
+				"index.json":                   `{"manifests":[{"digest":"` + nested + `"}]}`,
+				"blobs/sha256/" + nested[7:]:   `{"manifests":[{"digest":"` + manifest + `"}]}`,
+				"blobs/sha256/" + manifest[7:]: manifestDoc,
+			},
+			want: []string{layer1, layer2},
+		},
+		{
+			name:    "no index",
+			files:   map[string]string{"oci-layout": `{}`},
+			wantErr: true,
+		},
+		{
+			name: "missing manifest",
+			files: map[string]string{
+				"index.json": `{"manifests":[{"digest":"` + manifest + `"}]}`,
+			},
+			wantErr: true,
+		},
+		{
+			name: "multiple manifests",
+			files: map[string]string{
+				"index.json": `{"manifests":[{"digest":"` + manifest + `"},{"digest":"` + nested + `"}]}`,
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			tw := tar.NewWriter(&b)
+			for name, contents := range tt.files {
+				if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(contents)), Typeflag: tar.TypeReg}); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := tw.Write([]byte(contents)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ociLayerDigests(&b)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
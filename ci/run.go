@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+// runStopTimeout is the time given to the running function to exit once
+// interrupted, after which it is killed.
+const runStopTimeout = 5 * time.Second
+
+func run(ctx context.Context) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	fs.StringVar(&mainPackage, "main", "", "Main package of the function to run, defaults to a main package scaffolded to serve the function")
+	fs.StringVar(&goVersion, "go-version", "", "Go version with which to build (e.g. 1.20.1), defaults to the version required by go.mod")
+	fs.BoolVar(&watch, "watch", false, "Rebuilds and restarts the function every time its source changes")
+	fs.DurationVar(&debounce, "debounce", 300*time.Millisecond, "Time to wait for further changes before rebuilding with --watch")
+	addGoModulesFlags(fs)
+	fs.Parse(os.Args[2:])
+
+	if err := exportGoProxy(); err != nil {
+		return err
+	}
+
+	fn, err := NewFunction(".")
+	if err != nil {
+		return err
+	}
+	if fn.Name == "" {
+		return ErrNameRequired
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	c := getDaggerClient(ctx)
+	defer c.Close()
+
+	var proc *runningFunction
+	defer func() { proc.Stop() }()
+
+	if !watch {
+		bin, err := buildHostBinary(ctx, c, fn)
+		if err != nil {
+			return err
+		}
+		if proc, err = startFunction(fn, bin); err != nil {
+			return err
+		}
+		select {
+		case <-proc.done:
+			return proc.err
+		case <-ctx.Done():
+			return nil // interrupted
+		}
+	}
+
+	return watchSource(ctx, fn.Root, func(fn Function) error {
+		bin, err := buildHostBinary(ctx, c, fn)
+		if err != nil {
+			return err // keep the running function
+		}
+		proc.Stop()
+		proc, err = startFunction(fn, bin)
+		return err
+	})
+}
+
+// runningFunction is a function running on the host
+type runningFunction struct {
+	cmd  *exec.Cmd
+	done chan struct{} // closed once exited
+	err  error         // exit error, once done
+}
+
+// buildHostBinary builds the main package of the function with the cached Go
+// container for the platform of the host, exporting the binary to the
+// runtime data directory of the function.  Returns the path of the binary.
+func buildHostBinary(ctx context.Context, c *dagger.Client, fn Function) (string, error) {
+	appDir, err := functionSource(c, fn)
+	if err != nil {
+		return "", err
+	}
+	goImage, err := goToolchainImage(ctx, appDir, goVersion)
+	if err != nil {
+		return "", err
+	}
+
+	ctr := getGoContainer(c, fn, goImage)
+	appDir, pkg, err := mainSource(ctx, ctr, appDir)
+	if err != nil {
+		return "", err
+	}
+
+	out := "/out/" + fn.Name
+	bin := ctr.
+		WithMountedDirectory("/app", appDir).
+		WithEnvVariable("CGO_ENABLED", "0").
+		WithEnvVariable("GOOS", runtime.GOOS).
+		WithEnvVariable("GOARCH", runtime.GOARCH).
+		WithExec([]string{"go", "build", "-o", out, pkg}).
+		File(out)
+
+	path := filepath.Join(fn.Root, RunDataDir, "bin", fn.Name)
+	if _, err = bin.Export(ctx, path); err != nil {
+		return "", err
+	}
+	return path, os.Chmod(path, 0o755)
+}
+
+// startFunction starts the binary of the function on the host, with the
+// environment of the function.  Envs which are not available locally (from
+// Secrets or ConfigMaps) are omitted.
+func startFunction(fn Function, bin string) (*runningFunction, error) {
+	envs, err := Interpolate(fn.Run.Envs)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(bin)
+	cmd.Dir = fn.Root
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	for k, v := range envs {
+		if strings.HasPrefix(v, "{{") {
+			continue
+		}
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	fmt.Printf("Function '%v' running (pid %d)\n", fn.Name, cmd.Process.Pid)
+
+	p := &runningFunction{cmd: cmd, done: make(chan struct{})}
+	go func() {
+		p.err = cmd.Wait()
+		close(p.done)
+	}()
+	return p, nil
+}
+
+// Stop interrupts the running function, killing it if it does not exit in
+// time.  Stopping a function which is not running has no effect.
+func (p *runningFunction) Stop() {
+	if p == nil {
+		return
+	}
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+	p.cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-p.done:
+	case <-time.After(runStopTimeout):
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"dagger.io/dagger"
+)
+
+// scaffoldDir is the directory of the source into which the main package
+// wrapping the function is scaffolded.  The leading underscore keeps it out
+// of the './...' pattern of the function's own tooling.
+const scaffoldDir = "_func"
+
+// scaffoldMain is the main package which serves the Handle function of the
+// function package over HTTP, as the func buildpacks do.  The version and
+// commit are set with '-X main.version' and '-X main.commit'.
+var scaffoldMain = template.Must(template.New("main").Parse(`// Code generated by dagger-knative. DO NOT EDIT.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	function "{{.}}"
+)
+
+var (
+	version = "dev"
+	commit  = "unknown"
+)
+
+func main() {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health/readiness", func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	mux.HandleFunc("/health/liveness", func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) { function.Handle(r.Context(), w, r) })
+	srv := &http.Server{Addr: ":" + port, Handler: mux}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	log.Printf("function %v (%v) listening on :%v", version, commit, port)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+}
+`))
+
+// goModulePath returns the module path declared by the contents of a go.mod
+func goModulePath(gomod string) (string, error) {
+	directives, err := goModDirectives(gomod)
+	if err != nil {
+		return "", err
+	}
+	if module := directives["module"]; module != "" {
+		return module, nil
+	}
+	return "", errors.New("go.mod has no module directive")
+}
+
+// scaffoldMainSource returns the main package wrapping the function package
+// of the given module path.
+func scaffoldMainSource(module string) (string, error) {
+	var b bytes.Buffer
+	if err := scaffoldMain.Execute(&b, module); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// mainSource returns the source to build and the main package within it.
+// Without --main, a main package serving the function is scaffolded into the
+// source.  An explicit --main must name a main package, as building any other
+// package silently writes an archive instead of an executable.
+func mainSource(ctx context.Context, ctr *dagger.Container, src *dagger.Directory) (*dagger.Directory, string, error) {
+	if mainPackage != "" {
+		name, err := ctr.WithMountedDirectory("/app", src).
+			WithWorkdir("/app").
+			WithExec([]string{"go", "list", "-f", "{{.Name}}", mainPackage}).
+			Stdout(ctx)
+		if err != nil {
+			return nil, "", fmt.Errorf("unable to list package '%v': %w", mainPackage, err)
+		}
+		if name = strings.TrimSpace(name); name != "main" {
+			return nil, "", fmt.Errorf("package '%v' is package %v, not main; omit --main to build the function", mainPackage, name)
+		}
+		return src, mainPackage, nil
+	}
+
+	gomod, err := src.File("go.mod").Contents(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to read go.mod of the function to scaffold its main package: %w", err)
+	}
+	module, err := goModulePath(gomod)
+	if err != nil {
+		return nil, "", err
+	}
+	main, err := scaffoldMainSource(module)
+	if err != nil {
+		return nil, "", err
+	}
+	return src.WithNewFile(scaffoldDir+"/main.go", main), "./" + scaffoldDir, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestGoModulePath(t *testing.T) {
+	tests := []struct {
+		gomod string
+		want  string
+	}{
+		{gomod: "module function\n\ngo 1.20\n", want: "function"},
+		{gomod: "// comment\nmodule github.com/alice/fn\n", want: "github.com/alice/fn"},
+		{gomod: "module \"example.com/quoted\"\n", want: "example.com/quoted"},
+		{gomod: "module example.com/fn // deprecated\n\ngo 1.20\n", want: "example.com/fn"},
+		{gomod: "require (\n\tmodule v1.0.0\n)\nmodule function\n", want: "function"},
+	}
+	for _, tt := range tests {
+		got, err := goModulePath(tt.gomod)
+		if err != nil {
+			t.Errorf("goModulePath(%q): %v", tt.gomod, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("goModulePath(%q) = %q, want %q", tt.gomod, got, tt.want)
+		}
+	}
+
+	if _, err := goModulePath("go 1.20\n"); err == nil {
+		t.Error("expected an error for a go.mod without a module directive")
+	}
+}
+
+// TestScaffoldMain builds the scaffolded main package against the function
+// of this repository, ensuring it is an executable serving the function
+// which reports the injected version and commit.
+func TestScaffoldMain(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not available")
+	}
+	dir := t.TempDir()
+	handle, err := os.ReadFile(filepath.Join("..", "handle.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	main, err := scaffoldMainSource("function")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"go.mod":                              "module function\n\ngo 1.20\n",
+		"handle.go":                           string(handle),
+		filepath.Join(scaffoldDir, "main.go"): main,
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bin := filepath.Join(dir, "fn")
+	cmd := exec.Command("go", "build", "-ldflags", "-X main.version=v1.0.0 -X main.commit=abc", "-o", bin, "./"+scaffoldDir)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOPROXY=off")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+
+	// run it, serving the function with the injected version and commit
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+	l.Close()
+
+	var logs bytes.Buffer
+	cmd = exec.Command(bin)
+	cmd.Env = append(os.Environ(), "PORT="+port)
+	cmd.Stderr = &logs
+	if err = cmd.Start(); err != nil {
+		t.Fatalf("%v is not an executable: %v", bin, err)
+	}
+	defer cmd.Process.Kill()
+
+	var body []byte
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		res, err := http.Get("http://127.0.0.1:" + port + "/")
+		if err == nil {
+			body, err = io.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("function not serving: %v\n%s", err, logs.String())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if string(body) != "hello function" {
+		t.Errorf("response = %q, want that of the function", body)
+	}
+
+	if err = cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	if err = cmd.Wait(); err != nil {
+		t.Errorf("function did not shut down cleanly: %v\n%s", err, logs.String())
+	}
+	if want := "function v1.0.0 (abc) listening on :" + port; !strings.Contains(logs.String(), want) {
+		t.Errorf("logs = %q, want them to contain %q", logs.String(), want)
+	}
+}
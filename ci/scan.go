@@ -4,23 +4,25 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
-
-	"dagger.io/dagger"
 )
 
 func scan(ctx context.Context, source string) error {
 	c := getDaggerClient(ctx)
 	defer c.Close()
 
+	// the vulnerability database does not depend on the function, and so is
+	// shared by all functions
 	scanCache := c.CacheVolume("grype")
 
-	appDir := c.Host().Directory(".", dagger.HostDirectoryOpts{
-		Exclude: []string{"ci"},
-	})
+	opts, err := sourceDirectoryOpts(".")
+	if err != nil {
+		return err
+	}
+	appDir := c.Host().Directory(".", opts)
 
 	dockerSock := c.Host().UnixSocket("/var/run/docker.sock")
 
-	_, err := c.Container().From("anchore/grype").
+	_, err = c.Container().From("anchore/grype").
 		WithUnixSocket("/var/run/docker.sock", dockerSock).
 		WithMountedCache("/.cache", scanCache).
 		WithMountedDirectory("/app", appDir).
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"dagger.io/dagger"
+	"k8s.io/client-go/util/homedir"
+)
+
+// cosignImage is the image of the cosign CLI used to sign and verify images
+const cosignImage = "gcr.io/projectsigstore/cosign:v2.0.0"
+
+var (
+	// signingKey is the path of the cosign private key signing images
+	signingKey string
+
+	// verificationKey is the path of the cosign public key verifying images
+	verificationKey string
+
+	// tlog indicates to record signatures in (and verify them against) the
+	// Rekor transparency log.
+	tlog bool
+
+	// insecureRegistry allows registries over HTTP or with self-signed
+	// certificates, such as a local registry container.
+	insecureRegistry bool
+)
+
+// ErrImageDigestRequired indicates an operation requires the image of the
+// function to have been published, recording its digest.
+var ErrImageDigestRequired = errors.New("image digest required, publish the function first with 'push' or 'package --remote'")
+
+func sign(ctx context.Context) error {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	fs.StringVar(&signingKey, "key", "cosign.key", "Path of the cosign private key. Its password is read from $COSIGN_PASSWORD")
+	addCosignFlags(fs)
+	fs.Parse(os.Args[2:])
+
+	fn, err := NewFunction(".")
+	if err != nil {
+		return err
+	}
+	fn.ImageDigest = fn.PublishedDigest()
+	if fn.ImageDigest == "" {
+		return ErrImageDigestRequired
+	}
+
+	c := getDaggerClient(ctx)
+	defer c.Close()
+
+	return signImage(ctx, c, cosignContainer(c), fn.ImageWithDigest())
+}
+
+// signImage signs an image with the signing key, using the given cosign
+// container.
+func signImage(ctx context.Context, c *dagger.Client, ctr *dagger.Container, image string) error {
+	_, err := ctr.
+		WithMountedSecret("/cosign/cosign.key", hostFileSecret(c, signingKey)).
+		WithExec([]string{"sign", "--yes",
+			"--key", "/cosign/cosign.key",
+			fmt.Sprintf("--tlog-upload=%v", tlog),
+			fmt.Sprintf("--allow-insecure-registry=%v", insecureRegistry),
+			image,
+		}).ExitCode(ctx)
+	if err != nil {
+		return fmt.Errorf("signing '%v': %w", image, err)
+	}
+
+	fmt.Println("Signed", image)
+	return nil
+}
+
+func verify(ctx context.Context) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fs.StringVar(&verificationKey, "key", "cosign.pub", "Path of the cosign public key")
+	addCosignFlags(fs)
+	fs.Parse(os.Args[2:])
+
+	fn, err := NewFunction(".")
+	if err != nil {
+		return err
+	}
+	fn.ImageDigest = fn.PublishedDigest()
+
+	c := getDaggerClient(ctx)
+	defer c.Close()
+
+	return verifyImage(ctx, c, fn)
+}
+
+// addCosignFlags registers the flags shared by the commands signing and
+// verifying images.
+func addCosignFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&tlog, "tlog", false, "Records signatures in, and verifies them against, the Rekor transparency log")
+	fs.BoolVar(&insecureRegistry, "allow-insecure-registry", false, "Allows registries over HTTP or with self-signed certificates (e.g. a local registry)")
+}
+
+// verifyImage verifies the signature of the published image of the function
+// with the verification key.
+func verifyImage(ctx context.Context, c *dagger.Client, fn Function) error {
+	if fn.ImageDigest == "" {
+		return ErrImageDigestRequired
+	}
+	return verifyImageSignature(ctx, c, cosignContainer(c), fn.ImageWithDigest())
+}
+
+// verifyImageSignature verifies the signature of an image with the
+// verification key, using the given cosign container.
+func verifyImageSignature(ctx context.Context, c *dagger.Client, ctr *dagger.Container, image string) error {
+	_, err := ctr.
+		WithMountedFile("/cosign/cosign.pub", hostFile(c, verificationKey)).
+		WithExec([]string{"verify",
+			"--key", "/cosign/cosign.pub",
+			fmt.Sprintf("--insecure-ignore-tlog=%v", !tlog),
+			fmt.Sprintf("--allow-insecure-registry=%v", insecureRegistry),
+			image,
+		}).ExitCode(ctx)
+	if err != nil {
+		return fmt.Errorf("signature of '%v' could not be verified: %w", image, err)
+	}
+
+	fmt.Println("Verified signature of", image)
+	return nil
+}
+
+// cosignContainer returns a container of the cosign CLI, authenticated to
+// registries with the local docker config.  It runs as root, as secrets are
+// mounted readable by root only.
+func cosignContainer(c *dagger.Client) *dagger.Container {
+	dockerConfig := hostFileSecret(c, filepath.Join(homedir.HomeDir(), ".docker", "config.json"))
+	ctr := c.Container().From(cosignImage).
+		WithUser("0").
+		WithMountedSecret("/cosign/docker/config.json", dockerConfig).
+		WithEnvVariable("DOCKER_CONFIG", "/cosign/docker")
+	if _, ok := os.LookupEnv("COSIGN_PASSWORD"); ok {
+		ctr = ctr.WithSecretVariable("COSIGN_PASSWORD", c.Host().EnvVariable("COSIGN_PASSWORD").Secret())
+	}
+	return ctr
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+func TestPublishedDigest(t *testing.T) {
+	const (
+		declared  = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+		published = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	)
+	fn := Function{Root: t.TempDir(), Image: "localhost:5000/alice/fn", ImageDigest: declared}
+	if got := fn.PublishedDigest(); got != declared {
+		t.Errorf("digest before publishing = %v, want that of the function file %v", got, declared)
+	}
+	if err := fn.RecordPublished(published); err != nil {
+		t.Fatal(err)
+	}
+	if got := fn.PublishedDigest(); got != published {
+		t.Errorf("digest after publishing = %v, want %v", got, published)
+	}
+	if _, err := os.Stat(filepath.Join(fn.Root, FunctionFile)); !os.IsNotExist(err) {
+		t.Errorf("recording the published digest wrote the function file: %v", err)
+	}
+}
+
+// TestSignVerify signs an image in a local registry container and verifies
+// its signature, with the key which signed it and with another key.
+func TestSignVerify(t *testing.T) {
+	if testing.Short() {
+		t.Skip("requires a Dagger engine")
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("requires a Dagger engine, docker is not available")
+	}
+
+	// an empty docker config, as the registry requires no authentication
+	home := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(home, ".docker"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".docker", "config.json"), []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", home)
+	t.Setenv("COSIGN_PASSWORD", "test")
+
+	defer func(key, pub string, insecure, log bool) {
+		signingKey, verificationKey, insecureRegistry, tlog = key, pub, insecure, log
+	}(signingKey, verificationKey, insecureRegistry, tlog)
+	insecureRegistry, tlog = true, false
+
+	ctx := context.Background()
+	c, err := dagger.Connect(ctx, dagger.WithLogOutput(os.Stderr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// execs against the registry are never served from the cache, as each
+	// run has a registry of its own
+	nonce := strconv.FormatInt(time.Now().UnixNano(), 10)
+	registry := c.Container().From("registry:2").
+		WithEnvVariable("NONCE", nonce).
+		WithExposedPort(5000).
+		WithExec([]string{"/etc/docker/registry/config.yml"})
+
+	digest, err := c.Container().From("gcr.io/go-containerregistry/crane:debug").
+		WithServiceBinding("registry", registry).
+		WithEnvVariable("NONCE", nonce).
+		WithEntrypoint([]string{"/busybox/sh", "-c"}).
+		WithExec([]string{"crane copy --insecure alpine:3.17 registry:5000/alice/fn:latest >&2 && crane digest --insecure registry:5000/alice/fn:latest"}).
+		Stdout(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := Function{Image: "registry:5000/alice/fn:latest", ImageDigest: strings.TrimSpace(digest)}
+	image := fn.ImageWithDigest()
+
+	keys := t.TempDir()
+	for _, name := range []string{"signing", "other"} {
+		_, err = cosignContainer(c).
+			WithEnvVariable("NONCE", nonce).
+			WithWorkdir("/keys").
+			WithExec([]string{"generate-key-pair"}).
+			Directory("/keys").
+			Export(ctx, filepath.Join(keys, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cosign := cosignContainer(c).
+		WithServiceBinding("registry", registry).
+		WithEnvVariable("NONCE", nonce)
+
+	signingKey = filepath.Join(keys, "signing", "cosign.key")
+	if err = signImage(ctx, c, cosign, image); err != nil {
+		t.Fatal(err)
+	}
+
+	verificationKey = filepath.Join(keys, "signing", "cosign.pub")
+	if err = verifyImageSignature(ctx, c, cosign, image); err != nil {
+		t.Errorf("verifying with the signing key: %v", err)
+	}
+	verificationKey = filepath.Join(keys, "other", "cosign.pub")
+	if err = verifyImageSignature(ctx, c, cosign, image); err == nil {
+		t.Error("verifying with another key succeeded")
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+)
+
+func test(ctx context.Context) error {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	fs.StringVar(&goVersion, "go-version", "", "Go version with which to test (e.g. 1.20.1), defaults to the version required by go.mod")
+	fs.StringVar(&goVersions, "go-versions", "", "Comma separated list of Go versions to test with concurrently, reporting the result of each (e.g. 1.19,1.20)")
+	fs.BoolVar(&fromGit, "git", false, "Tests the source from the function's git repository and revision (build.git) instead of the local directory")
+	addGoModulesFlags(fs)
+	fs.Parse(os.Args[2:])
+
+	if err := exportGoProxy(); err != nil {
+		return err
+	}
+
+	fn, err := NewFunction(".")
+	if err != nil {
+		return err
+	}
+
+	c := getDaggerClient(ctx)
+	defer c.Close()
+
+	appDir, err := functionSource(c, fn)
+	if err != nil {
+		return err
+	}
+
+	return runGo(ctx, c, fn, appDir, []string{"go", "test", "./..."})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// goImageRepository is the repository of the Go toolchain images, tagged by
+// Go version.
+const goImageRepository = "golang"
+
+// regGoVersion matches a Go version as used in go.mod and the tags of the
+// toolchain images, ex. '1.20', '1.20.1' or '1.21rc2'
+var regGoVersion = regexp.MustCompile(`^1\.[0-9]+(?:\.[0-9]+|(?:rc|beta)[0-9]+)?$`)
+
+// goVersion is the Go version with which to build, overriding that of go.mod
+var goVersion string
+
+// goModVersion returns the Go version required by the contents of a go.mod
+// file.  The toolchain directive takes precedence over the go directive.
+func goModVersion(gomod string) (string, error) {
+	directives, err := goModDirectives(gomod)
+	if err != nil {
+		return "", err
+	}
+	goDirective := directives["go"]
+	toolchain := strings.TrimPrefix(directives["toolchain"], "go")
+
+	if toolchain != "" && toolchain != "default" {
+		return toolchain, nil
+	}
+	if goDirective != "" {
+		return goDirective, nil
+	}
+	return "", errors.New("go.mod has no go directive")
+}
+
+// goModDirectives returns the arguments of the single-argument directives
+// of the contents of a go.mod file by verb, ex. 'go' for 'go 1.20 // comment'.
+// Trailing comments are removed, and quoted arguments unquoted.  Blocks
+// (ex. 'require (') and directives of several arguments are skipped.
+func goModDirectives(gomod string) (map[string]string, error) {
+	directives := map[string]string{}
+	inBlock := false
+	scanner := bufio.NewScanner(strings.NewReader(gomod))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		switch {
+		case inBlock:
+			inBlock = len(fields) != 1 || fields[0] != ")"
+			continue
+		case len(fields) == 2 && fields[1] == "(":
+			inBlock = true
+			continue
+		case len(fields) != 2:
+			continue
+		}
+		arg := fields[1]
+		if strings.HasPrefix(arg, `"`) || strings.HasPrefix(arg, "`") {
+			unquoted, err := strconv.Unquote(arg)
+			if err != nil {
+				continue
+			}
+			arg = unquoted
+		}
+		directives[fields[0]] = arg
+	}
+	return directives, scanner.Err()
+}
+
+// goToolchainImage returns the Go image matching the version required by the
+// go.mod of the given source, or the version given explicitly if provided.
+func goToolchainImage(ctx context.Context, src *dagger.Directory, version string) (string, error) {
+	if version == "" {
+		gomod, err := src.File("go.mod").Contents(ctx)
+		if err != nil {
+			return "", fmt.Errorf("unable to read go.mod of the function to derive the Go version, set one explicitly with --go-version: %w", err)
+		}
+		if version, err = goModVersion(gomod); err != nil {
+			return "", err
+		}
+	}
+	if !regGoVersion.MatchString(version) {
+		return "", fmt.Errorf("go version '%v' is not valid, expected form '1.N[.P]'", version)
+	}
+	return goImageRepository + ":" + version, nil
+}
+
+// checkGoContainer ensures the toolchain image of the container is available
+// by running the Go command, failing clearly otherwise.
+func checkGoContainer(ctx context.Context, ctr *dagger.Container, image string) error {
+	out, err := ctr.WithExec([]string{"go", "version"}).Stdout(ctx)
+	if err != nil {
+		return fmt.Errorf("go toolchain image '%v' is not available: %w", image, err)
+	}
+	fmt.Print(out)
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGoModVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		gomod   string
+		want    string
+		wantErr bool
+	}{
+		{name: "go directive", gomod: "module function\n\ngo 1.20\n", want: "1.20"},
+		{name: "patch version", gomod: "module function\ngo 1.21.3\n", want: "1.21.3"},
+		{name: "trailing comment", gomod: "module function\ngo 1.20 // minimum\n", want: "1.20"},
+		{name: "comment without space", gomod: "module function\ngo 1.20//minimum\n", want: "1.20"},
+		{name: "toolchain directive", gomod: "module function\ngo 1.21\ntoolchain go1.21.5\n", want: "1.21.5"},
+		{name: "toolchain before go", gomod: "module function\ntoolchain go1.22.1\ngo 1.21\n", want: "1.22.1"},
+		{name: "toolchain with comment", gomod: "module function\ngo 1.21\ntoolchain go1.21.5 // pinned\n", want: "1.21.5"},
+		{name: "default toolchain", gomod: "module function\ngo 1.21\ntoolchain default\n", want: "1.21"},
+		{name: "commented out go", gomod: "module function\n// go 1.19\ngo 1.20\n", want: "1.20"},
+		{name: "require block", gomod: "module function\n\ngo 1.20\n\nrequire (\n\tdagger.io/dagger v0.4.6\n\tgo v1.0.0 // indirect\n)\n", want: "1.20"},
+		{name: "no go directive", gomod: "module function\n", wantErr: true},
+		{name: "empty", gomod: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := goModVersion(tt.gomod)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGoToolchainImage(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string
+		wantErr bool
+	}{
+		{version: "1.20", want: "golang:1.20"},
+		{version: "1.20.1", want: "golang:1.20.1"},
+		{version: "1.21rc2", want: "golang:1.21rc2"},
+		{version: "go1.20", wantErr: true},
+		{version: "1.20; rm -rf /", wantErr: true},
+		{version: "2.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		// an explicit version does not read the source
+		got, err := goToolchainImage(context.Background(), nil, tt.version)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("goToolchainImage(%q) = %q, expected an error", tt.version, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("goToolchainImage(%q): %v", tt.version, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("goToolchainImage(%q) = %q, want %q", tt.version, got, tt.want)
+		}
+	}
+}
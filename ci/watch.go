@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// watchPollInterval is the interval at which the source of a watched
+// function is checked for changes.
+const watchPollInterval = 500 * time.Millisecond
+
+var (
+	// watch indicates to rebuild (and restart) on changes to the source.
+	watch bool
+
+	// debounce is the time without further changes to wait for after a
+	// change to the source before rebuilding.
+	debounce time.Duration
+)
+
+// errWatchGit indicates watching was requested for a source fetched from git
+var errWatchGit = errors.New("watching the local directory can not be combined with building from git")
+
+// watchSource calls onChange with the function at root, once at start and
+// then every time its source changes, until the context is done.
+// Changes are detected by polling the fingerprint of the function, and so
+// honour its ignore rules.  Once a change is seen, onChange is only called
+// after the source has not changed further for the debounce period.
+// Errors of onChange are reported and watching continues.
+func watchSource(ctx context.Context, root string, onChange func(Function) error) error {
+	fn, last, err := watchedFingerprint(root)
+	if err != nil {
+		return err
+	}
+	if err = onChange(fn); err != nil {
+		fmt.Println("Error:", err)
+	}
+	fmt.Println("Watching for changes (Ctrl-C to stop)")
+
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		_, current, err := watchedFingerprint(root)
+		if err != nil {
+			fmt.Println("Error:", err)
+			continue
+		}
+		if current == last {
+			continue
+		}
+
+		// debounce until the source settles
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(debounce):
+			}
+			var settled string
+			fn, settled, err = watchedFingerprint(root)
+			if err != nil {
+				break
+			}
+			if settled == current {
+				break
+			}
+			current = settled
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			continue
+		}
+		last = current
+
+		fmt.Println("Change detected, rebuilding")
+		if err = onChange(fn); err != nil {
+			fmt.Println("Error:", err)
+		}
+	}
+}
+
+// watchedFingerprint loads the function at root, such that changes to its
+// spec are also picked up, and returns it along with its fingerprint.
+func watchedFingerprint(root string) (Function, string, error) {
+	fn, err := NewFunction(root)
+	if err != nil {
+		return fn, "", err
+	}
+	fingerprint, err := fn.Fingerprint()
+	return fn, fingerprint, err
+}